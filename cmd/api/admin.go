@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"sulfur.test.net/internal/data"
+	"sulfur.test.net/internal/data/validator"
+	"sulfur.test.net/internal/mailer"
+)
+
+// Admin commands run against the configured database instead of starting the
+// server, e.g. "api -db-dsn=... user activate alice@example.com". They use the
+// same validation as the HTTP handlers and write their result as JSON to
+// stdout for scripting.
+
+type adminCommand struct {
+	usage string
+	run   func(ctx context.Context, models data.Models, stdin io.Reader, args []string) (envelope, error)
+}
+
+var adminCommands = map[string]adminCommand{
+	"user create":      {"-name NAME -email EMAIL [-password PASSWORD] [-language LANG] [-activated] [-permissions CODES]", adminCreateUser},
+	"user activate":    {"EMAIL", adminActivateUser},
+	"permission grant": {"EMAIL CODE...", adminGrantPermissions},
+	"token purge":      {"[-batch-size N]", adminPurgeTokens},
+	"session revoke":   {"EMAIL", adminRevokeSessions},
+	"apikey create":    {"[-ttl DURATION] EMAIL", adminCreateAPIKey},
+	"apikey revoke":    {"EMAIL", adminRevokeAPIKeys},
+}
+
+// failedValidationError is returned by admin commands for bad input, with the
+// same keys and messages as the API's failed validation responses.
+type failedValidationError map[string]string
+
+func (e failedValidationError) Error() string {
+	return "failed validation"
+}
+
+func adminUsage(w io.Writer) {
+	names := make([]string, 0, len(adminCommands))
+	for name := range adminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "\nAdmin commands:")
+	for _, name := range names {
+		fmt.Fprintln(w, strings.TrimSpace("  "+name+" "+adminCommands[name].usage))
+	}
+}
+
+// runAdmin runs the admin command in args and writes its result to stdout.
+func runAdmin(ctx context.Context, models data.Models, stdin io.Reader, stdout io.Writer, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+	}
+	name := args[0] + " " + args[1]
+	command, ok := adminCommands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	result, err := command.run(ctx, models, stdin, args[2:])
+	if err != nil {
+		return err
+	}
+	js, err := json.MarshalIndent(result, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(append(js, '\n'))
+	return err
+}
+
+// writeAdminError writes a failed validation as {"error": {...}}, like the
+// API does, so scripts can handle it the same way.
+func writeAdminError(w io.Writer, err error) bool {
+	var invalid failedValidationError
+	if !errors.As(err, &invalid) {
+		return false
+	}
+	js, _ := json.MarshalIndent(envelope{"error": invalid}, "", "\t")
+	w.Write(append(js, '\n'))
+	return true
+}
+
+func adminFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// adminCreateUser creates a user with the given permissions, by default the
+// same ones as a user who registers. Without -password the password is read
+// from the first line of stdin, which keeps it out of the shell history.
+func adminCreateUser(ctx context.Context, models data.Models, stdin io.Reader, args []string) (envelope, error) {
+	var input struct {
+		name        string
+		email       string
+		password    string
+		language    string
+		activated   bool
+		permissions []string
+	}
+	input.permissions = []string{"movies:read"}
+	fs := adminFlagSet("user create")
+	fs.StringVar(&input.name, "name", "", "Name")
+	fs.StringVar(&input.email, "email", "", "Email address")
+	fs.StringVar(&input.password, "password", "", "Password (read from stdin if empty)")
+	fs.StringVar(&input.language, "language", "", "Language for emails")
+	fs.BoolVar(&input.activated, "activated", false, "Create the user already activated")
+	fs.Var(stringsFlag{dst: &input.permissions}, "permissions", "Permission codes to grant (space separated)")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("user create: unexpected argument %q", fs.Arg(0))
+	}
+	if input.password == "" {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		input.password = strings.TrimRight(line, "\r\n")
+	}
+
+	user := &data.User{
+		Name:      input.name,
+		Email:     input.email,
+		Activated: input.activated,
+		Language:  input.language,
+	}
+	err = user.Password.Set(input.password)
+	if err != nil {
+		return nil, err
+	}
+	locales, err := mailer.Locales()
+	if err != nil {
+		return nil, err
+	}
+	v := validator.New()
+	data.ValidateUser(v, user)
+	if data.ValidateLanguage(v, user.Language, locales); !v.Valid() {
+		return nil, failedValidationError(v.Errors)
+	}
+	err = validatePermissions(ctx, models, v, input.permissions)
+	if err != nil {
+		return nil, err
+	}
+	if !v.Valid() {
+		return nil, failedValidationError(v.Errors)
+	}
+
+	err = models.Users.Insert(ctx, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			return nil, failedValidationError(v.Errors)
+		default:
+			return nil, err
+		}
+	}
+	err = models.Permissions.AddForUser(ctx, user.ID, input.permissions...)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"user": user, "permissions": data.Permissions(input.permissions)}, nil
+}
+
+func adminActivateUser(ctx context.Context, models data.Models, stdin io.Reader, args []string) (envelope, error) {
+	user, err := adminUserArg(ctx, models, args)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Activated {
+		user.Activated = true
+		err = models.Users.Update(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = models.Tokens.DeleteAllForUser(ctx, data.ScopeActivation, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"user": user}, nil
+}
+
+func adminGrantPermissions(ctx context.Context, models data.Models, stdin io.Reader, args []string) (envelope, error) {
+	v := validator.New()
+	v.Check(len(args) > 1, "permissions", "must be provided")
+	if !v.Valid() {
+		return nil, failedValidationError(v.Errors)
+	}
+	codes := args[1:]
+	err := validatePermissions(ctx, models, v, codes)
+	if err != nil {
+		return nil, err
+	}
+	if !v.Valid() {
+		return nil, failedValidationError(v.Errors)
+	}
+	user, err := adminUserArg(ctx, models, args[:1])
+	if err != nil {
+		return nil, err
+	}
+	err = models.Permissions.AddForUser(ctx, user.ID, codes...)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"user": user, "permissions": permissions}, nil
+}
+
+func adminPurgeTokens(ctx context.Context, models data.Models, stdin io.Reader, args []string) (envelope, error) {
+	fs := adminFlagSet("token purge")
+	batchSize := fs.Int("batch-size", 1000, "Expired tokens deleted per statement")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("token purge: unexpected argument %q", fs.Arg(0))
+	}
+	v := validator.New()
+	if v.Check(*batchSize > 0, "batch-size", "must be greater than zero"); !v.Valid() {
+		return nil, failedValidationError(v.Errors)
+	}
+	deleted, err := purgeExpiredTokens(ctx, models.Tokens, *batchSize)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"deleted": deleted}, nil
+}
+
+// adminRevokeSessions deletes a user's authentication tokens, signing them
+// out everywhere.
+func adminRevokeSessions(ctx context.Context, models data.Models, stdin io.Reader, args []string) (envelope, error) {
+	user, err := adminUserArg(ctx, models, args)
+	if err != nil {
+		return nil, err
+	}
+	err = models.Tokens.DeleteAllForUser(ctx, data.ScopeAuthentication, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"user": user, "sessions_revoked": true}, nil
+}
+
+// adminCreateAPIKey issues an API key for a user. The plaintext is only shown
+// here; the id is what the rate limiter and logs know it by.
+func adminCreateAPIKey(ctx context.Context, models data.Models, stdin io.Reader, args []string) (envelope, error) {
+	fs := adminFlagSet("apikey create")
+	ttl := fs.Duration("ttl", 365*24*time.Hour, "How long the key is valid for")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	v := validator.New()
+	if v.Check(*ttl > 0, "ttl", "must be greater than zero"); !v.Valid() {
+		return nil, failedValidationError(v.Errors)
+	}
+	user, err := adminUserArg(ctx, models, fs.Args())
+	if err != nil {
+		return nil, err
+	}
+	token, err := models.Tokens.New(ctx, user.ID, *ttl, data.ScopeAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"user": user, "api_key": token, "id": data.TokenID(token.Plaintext)}, nil
+}
+
+// adminRevokeAPIKeys deletes all of a user's API keys.
+func adminRevokeAPIKeys(ctx context.Context, models data.Models, stdin io.Reader, args []string) (envelope, error) {
+	user, err := adminUserArg(ctx, models, args)
+	if err != nil {
+		return nil, err
+	}
+	err = models.Tokens.DeleteAllForUser(ctx, data.ScopeAPIKey, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return envelope{"user": user, "api_keys_revoked": true}, nil
+}
+
+// adminUserArg looks up the user whose email address is the only argument.
+func adminUserArg(ctx context.Context, models data.Models, args []string) (*data.User, error) {
+	v := validator.New()
+	v.Check(len(args) <= 1, "email", "must be the only argument")
+	email := ""
+	if len(args) > 0 {
+		email = args[0]
+	}
+	if data.ValidateEmail(v, email); !v.Valid() {
+		return nil, failedValidationError(v.Errors)
+	}
+	user, err := models.Users.GetByEmail(ctx, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			v.AddError("email", "no matching email address found")
+			return nil, failedValidationError(v.Errors)
+		default:
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// validatePermissions checks that each code is a permission in the database,
+// since granting an unknown one would silently do nothing.
+func validatePermissions(ctx context.Context, models data.Models, v *validator.Validator, codes []string) error {
+	known, err := models.Permissions.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if !known.Include(code) {
+			v.AddError("permissions", fmt.Sprintf("%s is not a permission", code))
+			break
+		}
+	}
+	return nil
+}
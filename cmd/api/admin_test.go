@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sulfur.test.net/internal/data"
+)
+
+// The cases here fail validation before touching the database, so the models
+// have none.
+func TestRunAdmin(t *testing.T) {
+	tests := []struct {
+		args    []string
+		stdin   string
+		invalid map[string]string
+	}{
+		{args: []string{"user", "activate"}, invalid: map[string]string{"email": "must be provided"}},
+		{args: []string{"session", "revoke", "not-an-email"}, invalid: map[string]string{"email": "must be a valid email address"}},
+		{args: []string{"permission", "grant", "alice@example.com"}, invalid: map[string]string{"permissions": "must be provided"}},
+		{args: []string{"apikey", "create", "-ttl", "0s", "alice@example.com"}, invalid: map[string]string{"ttl": "must be greater than zero"}},
+		{
+			args:    []string{"user", "create", "-email", "alice@example.com"},
+			stdin:   "short\n",
+			invalid: map[string]string{"name": "must be provided", "password": "must be at least 8 bytes long"},
+		},
+	}
+	for _, tt := range tests {
+		var stdout bytes.Buffer
+		err := runAdmin(context.Background(), data.Models{}, strings.NewReader(tt.stdin), &stdout, tt.args)
+		var out bytes.Buffer
+		if assert.True(t, writeAdminError(&out, err), "%v: %v", tt.args, err) {
+			assert.Equal(t, failedValidationError(tt.invalid), err, "%v", tt.args)
+		}
+		assert.Empty(t, stdout.String())
+	}
+
+	err := runAdmin(context.Background(), data.Models{}, nil, &bytes.Buffer{}, []string{"user", "delete"})
+	assert.EqualError(t, err, `unknown command "user delete"`)
+	assert.False(t, writeAdminError(&bytes.Buffer{}, err))
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"sulfur.test.net/internal/data/jsonlog"
+	"sulfur.test.net/internal/data/validator"
+	"sulfur.test.net/internal/ratelimit"
+)
+
+// Configuration is layered, each layer overriding the one before: the flag
+// defaults, a YAML file named by -config or GREENLIGHT_CONFIG, GREENLIGHT_*
+// environment variables and finally the command line. Every setting is a
+// flag and the file and environment set flags by name, so each setting is
+// declared and parsed in one place.
+
+const envPrefix = "GREENLIGHT_"
+
+// commandLineOnly flags control the program rather than configure it.
+var commandLineOnly = map[string]bool{"config": true, "print-config": true, "version": true}
+
+// secretFlags are redacted by -print-config.
+var secretFlags = map[string]bool{
+	"db-dsn":              true,
+	"smtp-password":       true,
+	"debug-password":      true,
+	"mail-webhook-secret": true,
+}
+
+// multiValue is implemented by flags that may be given more than once. In the
+// config file they take a list and in the environment a comma separated one.
+// Reset clears the values set so far.
+type multiValue interface {
+	flag.Value
+	Values() []string
+	Reset()
+}
+
+// startLayer is called before each configuration layer (the file, the
+// environment, the command line) sets flags in fs. A repeatable flag that the
+// layer sets replaces the values of earlier layers, including its default,
+// rather than adding to them.
+func startLayer(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := f.Value.(*layerValue); ok {
+			f.Value = v.multiValue
+		}
+		if v, ok := f.Value.(multiValue); ok {
+			f.Value = &layerValue{multiValue: v}
+		}
+	})
+}
+
+// layerValue resets a repeatable flag the first time it is set in a layer.
+type layerValue struct {
+	multiValue
+	set bool
+}
+
+func (v *layerValue) Set(val string) error {
+	if !v.set {
+		v.multiValue.Reset()
+		v.set = true
+	}
+	return v.multiValue.Set(val)
+}
+
+// String is called on a zero layerValue by flag.PrintDefaults.
+func (v *layerValue) String() string {
+	if v.multiValue == nil {
+		return ""
+	}
+	return v.multiValue.String()
+}
+
+// commandFlags control the program rather than configure it.
+type commandFlags struct {
+	printConfig *bool
+	version     *bool
+}
+
+// newConfigFlagSet declares every setting as a flag that writes to cfg.
+func newConfigFlagSet(cfg *config, errorHandling flag.ErrorHandling) (*flag.FlagSet, commandFlags) {
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+	var cmd commandFlags
+	fs.IntVar(&cfg.port, "port", 4000, "Api server port")
+	fs.StringVar(&cfg.env, "env", "development", "Enviroment (development|staging|production)")
+
+	fs.StringVar(&cfg.defaultLanguage, "default-language", "en", "Language of the original movie titles and synopses")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL dsn")
+
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	fs.BoolVar(&cfg.db.migrateOnStart, "migrate-on-start", false, "Apply pending database migrations before serving; replicas wait for each other")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter per seconds")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 2, "Rate limiter maximum burst")
+	fs.Float64Var(&cfg.limiter.apiKey.RPS, "limiter-apikey-rps", 10, "Rate limiter per seconds for each API key; route policies are scaled by its ratio to the global policy")
+	fs.IntVar(&cfg.limiter.apiKey.Burst, "limiter-apikey-burst", 20, "Rate limiter maximum burst for each API key")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.StringVar(&cfg.limiter.backend, "limiter-backend", "memory", "Rate limiter state (memory|postgres)")
+	fs.DurationVar(&cfg.limiter.timeout, "limiter-db-timeout", 50*time.Millisecond, "Time to wait for the postgres rate limiter before falling back to the local one")
+
+	cfg.limiter.routes = map[string]ratelimit.Policy{
+		"POST /v1/tokens/authentication": {RPS: 0.2, Burst: 5},
+		"POST /v1/users":                 {RPS: 0.2, Burst: 5},
+		"GET /v1/movies":                 {RPS: 10, Burst: 20},
+		"GET /v1/movies/suggest":         {RPS: 5, Burst: 20},
+		"POST /v1/mail/events":           {RPS: 1, Burst: 5},
+	}
+	fs.Var(routesFlag{dst: cfg.limiter.routes}, "limiter-route", `Rate limit policy for one route as "METHOD /pattern=rps:burst" (repeatable)`)
+	fs.Var(tiersFlag{dst: &cfg.limiter.tiers}, "limiter-tier", `Rate limit policy for users with a permission as "code=rps:burst"; route policies are scaled by its ratio to the global policy (repeatable)`)
+
+	fs.StringVar(&cfg.mail.transport, "mail-transport", "log", "How email is delivered (smtp|outbox|log; production requires smtp)")
+	fs.StringVar(&cfg.mail.outboxDir, "mail-outbox-dir", "./outbox", "Directory the outbox transport writes .eml files to")
+	fs.StringVar(&cfg.mail.webhookSecret, "mail-webhook-secret", "", "Shared secret for the bounce and complaint webhook (disabled if empty)")
+
+	fs.StringVar(&cfg.dkim.domain, "dkim-domain", "", "DKIM signing domain")
+	fs.StringVar(&cfg.dkim.selector, "dkim-selector", "", "DKIM selector")
+	fs.StringVar(&cfg.dkim.keyFile, "dkim-private-key-file", "", "PEM encoded DKIM private key (signing disabled if empty)")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.alexedwards.net>", "SMTP sender")
+
+	fs.Var(stringsFlag{dst: &cfg.cors.trustedOrigings}, "cors-trusted-origins", "Trusted CORS origins (space separated)")
+
+	fs.Int64Var(&cfg.posters.maxBytes, "poster-max-bytes", 5<<20, "Maximum poster upload size in bytes")
+	fs.StringVar(&cfg.posters.storageDir, "storage-dir", "./uploads", "Directory for uploaded files")
+	fs.StringVar(&cfg.posters.baseURL, "storage-base-url", "/v1/posters", "Base URL uploaded files are served from")
+
+	fs.DurationVar(&cfg.health.readyTimeout, "ready-timeout", 2*time.Second, "Time allowed for the readiness checks")
+	fs.BoolVar(&cfg.health.checkSMTP, "ready-check-smtp", false, "Report whether the SMTP server is reachable in readiness checks")
+	fs.IntVar(&cfg.health.maxJobBacklog, "ready-max-job-backlog", 1000, "Due jobs waiting for a worker before readiness checks warn (0 disables the check)")
+	fs.DurationVar(&cfg.health.drainDelay, "shutdown-drain-delay", 0, "Time between failing readiness checks and closing listeners on shutdown, for load balancers to stop sending traffic")
+	fs.DurationVar(&cfg.shutdown.timeout, "shutdown-timeout", 25*time.Second, "Time allowed for the whole shutdown, drain delay included; keep it below the orchestrator's kill timeout")
+	fs.DurationVar(&cfg.shutdown.httpTimeout, "shutdown-http-timeout", 15*time.Second, "Time in-flight requests get to complete on shutdown before connections are closed")
+	fs.DurationVar(&cfg.shutdown.taskTimeout, "shutdown-task-timeout", 5*time.Second, "Time background tasks and running jobs get to finish on shutdown before they are cancelled")
+
+	fs.StringVar(&cfg.tls.certFile, "tls-cert-file", "", "PEM certificate chain; with -tls-key-file the API is served over HTTPS")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key-file", "", "PEM private key for -tls-cert-file")
+	fs.DurationVar(&cfg.tls.reloadInterval, "tls-reload-interval", 0, "How often to check the certificate files for changes and reload them (0 disables)")
+	fs.StringVar(&cfg.tls.minVersion, "tls-min-version", "1.2", "Minimum TLS version (1.2|1.3)")
+	fs.Var(stringsFlag{dst: &cfg.tls.cipherSuites}, "tls-cipher-suites", "TLS 1.2 cipher suites, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (space separated; Go's defaults if empty)")
+	fs.StringVar(&cfg.tls.redirectAddr, "tls-redirect-addr", "", "Listen address for a plain HTTP server that redirects to HTTPS (e.g. :80)")
+	fs.DurationVar(&cfg.tls.hstsMaxAge, "hsts-max-age", 0, "Strict-Transport-Security max-age (0 disables the header)")
+	fs.BoolVar(&cfg.tls.hstsIncludeSubdomains, "hsts-include-subdomains", false, "Add includeSubDomains to the Strict-Transport-Security header")
+
+	fs.Var(stringsFlag{dst: &cfg.trustedProxies}, "trusted-proxies", "Trusted reverse proxy addresses or CIDR ranges (space separated)")
+
+	fs.StringVar(&cfg.debug.addr, "debug-addr", "", "Separate listen address for /debug/vars, /metrics and pprof (e.g. localhost:4001)")
+	fs.StringVar(&cfg.debug.username, "debug-username", "", "Basic auth username for the debug endpoints (required unless debug-addr is a loopback address)")
+	fs.StringVar(&cfg.debug.password, "debug-password", "", "Basic auth password for the debug endpoints")
+	fs.BoolVar(&cfg.debug.pprof, "debug-pprof", false, "Serve net/http/pprof profiles on the debug listener")
+
+	cfg.log.level = jsonlog.LevelInfo
+	fs.Var(levelFlag{dst: &cfg.log.level}, "log-level", "Minimum log level (debug|info|warn|error|fatal|off)")
+	cfg.log.traceLevel = jsonlog.LevelError
+	fs.Var(levelFlag{dst: &cfg.log.traceLevel}, "log-trace-level", "Attach stack traces from this log level up (debug|info|warn|error|fatal|off)")
+	fs.DurationVar(&cfg.log.sampleInterval, "log-sample-interval", 0, "Sample repeated debug, info and warn messages over this interval (0 disables sampling)")
+	fs.Uint64Var(&cfg.log.sampleFirst, "log-sample-first", 100, "Repeats of a message logged per sample interval before sampling starts")
+	fs.Uint64Var(&cfg.log.sampleThereafter, "log-sample-thereafter", 100, "Once sampling, log every nth repeat of a message")
+
+	fs.IntVar(&cfg.jobs.workers, "jobs-workers", 2, "Number of background job workers")
+	fs.DurationVar(&cfg.jobs.pollInterval, "jobs-poll-interval", time.Second, "How often idle job workers look for due jobs")
+	fs.IntVar(&cfg.jobs.maxAttempts, "jobs-max-attempts", 8, "Attempts before a job is marked dead")
+
+	fs.DurationVar(&cfg.tokens.cleanupInterval, "tokens-cleanup-interval", time.Hour, "How often expired tokens are deleted (0 disables)")
+	fs.IntVar(&cfg.tokens.cleanupBatchSize, "tokens-cleanup-batch-size", 1000, "Expired tokens deleted per statement")
+
+	fs.StringVar(&cfg.tracing.exporter, "trace-exporter", "none", "Trace span exporter (none|stdout|file)")
+	fs.StringVar(&cfg.tracing.file, "trace-file", "traces.jsonl", "File spans are appended to by the file exporter")
+	fs.Float64Var(&cfg.tracing.sampleRatio, "trace-sample-ratio", 1, "Ratio of new traces to sample (0 to 1)")
+
+	fs.String("config", "", "YAML configuration file; settings are named after flags (also GREENLIGHT_CONFIG)")
+	cmd.printConfig = fs.Bool("print-config", false, "Display the configuration, with secrets redacted, and exit")
+	cmd.version = fs.Bool("version", false, "Display version and exit")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [COMMAND]\n\nMigration commands:\n  migrate up|down [N]|goto VERSION|version|force VERSION\n", fs.Name())
+		adminUsage(fs.Output())
+		fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+	return fs, cmd
+}
+
+// loadConfig sets the flags in fs from each layer in turn and returns the
+// config file used, if any.
+func loadConfig(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (string, error) {
+	file := configFileArg(args)
+	if file == "" {
+		file, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+	if file != "" {
+		startLayer(fs)
+		err := loadConfigFile(fs, file)
+		if err != nil {
+			return "", err
+		}
+	}
+	startLayer(fs)
+	err := loadConfigEnv(fs, lookupEnv)
+	if err != nil {
+		return "", err
+	}
+	startLayer(fs)
+	return file, fs.Parse(args)
+}
+
+// configFileArg finds -config on the command line, which has to be read
+// before the rest so that the command line can override the file.
+func configFileArg(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// loadConfigFile reads a YAML mapping of flag names to values. Mappings may
+// be nested, joining keys with "-", so db: {dsn: ...} sets -db-dsn.
+func loadConfigFile(fs *flag.FlagSet, file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var settings map[string]any
+	err = yaml.Unmarshal(b, &settings)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	values := make(map[string]any)
+	flattenSettings("", settings, values)
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || commandLineOnly[name] {
+			return fmt.Errorf("%s: unknown setting %q", file, name)
+		}
+		var vals []string
+		switch value := values[name].(type) {
+		case []any:
+			for _, v := range value {
+				vals = append(vals, settingString(v))
+			}
+			if _, ok := f.Value.(multiValue); !ok {
+				vals = []string{strings.Join(vals, " ")}
+			}
+		default:
+			vals = []string{settingString(value)}
+		}
+		for _, val := range vals {
+			err = fs.Set(name, val)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %w", file, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func flattenSettings(prefix string, settings map[string]any, values map[string]any) {
+	for key, value := range settings {
+		if prefix != "" {
+			key = prefix + "-" + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			flattenSettings(key, nested, values)
+			continue
+		}
+		values[key] = value
+	}
+}
+
+func settingString(value any) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// loadConfigEnv sets each flag from its environment variable, e.g. -db-dsn
+// from GREENLIGHT_DB_DSN.
+func loadConfigEnv(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || commandLineOnly[f.Name] {
+			return
+		}
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		val, found := lookupEnv(name)
+		if !found {
+			return
+		}
+		vals := []string{val}
+		if _, ok := f.Value.(multiValue); ok {
+			vals = strings.Split(val, ",")
+		}
+		for _, val := range vals {
+			err = fs.Set(f.Name, strings.TrimSpace(val))
+			if err != nil {
+				err = fmt.Errorf("%s: %w", name, err)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// printConfig writes the settings in fs as a config file, with secrets
+// redacted.
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	settings := make(map[string]any)
+	fs.VisitAll(func(f *flag.Flag) {
+		if commandLineOnly[f.Name] {
+			return
+		}
+		var value any = f.Value.String()
+		switch v := f.Value.(type) {
+		case multiValue:
+			value = v.Values()
+		case flag.Getter:
+			value = v.Get()
+			if d, ok := value.(time.Duration); ok {
+				value = d.String()
+			}
+		}
+		if secretFlags[f.Name] {
+			value = redact(f.Name, f.Value.String())
+		}
+		settings[f.Name] = value
+	})
+	b, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func redact(name, value string) string {
+	if value == "" {
+		return ""
+	}
+	// Keep the rest of a URL DSN, which is useful when debugging.
+	if name == "db-dsn" {
+		u, err := url.Parse(value)
+		if err == nil && u.User != nil {
+			if _, hasPassword := u.User.Password(); hasPassword {
+				return u.Redacted()
+			}
+		}
+	}
+	return "xxxxx"
+}
+
+func validateConfig(v *validator.Validator, cfg config) {
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns > 0, "db-max-open-conns", "must be greater than zero")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	_, err := time.ParseDuration(cfg.db.maxIdleTime)
+	v.Check(err == nil, "db-max-idle-time", "must be a duration such as 15m")
+
+	v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+	v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+	v.Check(cfg.limiter.apiKey.RPS > 0, "limiter-apikey-rps", "must be greater than zero")
+	v.Check(cfg.limiter.apiKey.Burst > 0, "limiter-apikey-burst", "must be greater than zero")
+	v.Check(validator.PermittedValue(cfg.limiter.backend, "memory", "postgres"), "limiter-backend", "must be memory or postgres")
+
+	v.Check(validator.PermittedValue(cfg.mail.transport, "smtp", "outbox", "log"), "mail-transport", "must be smtp, outbox or log")
+	// The log and outbox transports never deliver anything, so a production
+	// server using them would silently drop every email.
+	v.Check(cfg.env != "production" || cfg.mail.transport == "smtp", "mail-transport", "must be smtp in production")
+	_, err = mail.ParseAddress(cfg.smtp.sender)
+	v.Check(err == nil, "smtp-sender", "must be a valid email address")
+	if cfg.mail.transport == "smtp" {
+		v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+		v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	}
+	v.Check(cfg.smtp.username == "" || cfg.smtp.password != "", "smtp-password", "must be provided with smtp-username")
+	if cfg.dkim.keyFile != "" {
+		v.Check(cfg.dkim.domain != "", "dkim-domain", "must be provided with dkim-private-key-file")
+		v.Check(cfg.dkim.selector != "", "dkim-selector", "must be provided with dkim-private-key-file")
+	}
+
+	v.Check(cfg.posters.maxBytes > 0, "poster-max-bytes", "must be greater than zero")
+	v.Check(cfg.debug.username == "" || cfg.debug.password != "", "debug-password", "must be provided with debug-username")
+	// pprof and config reloads must not be open to anyone who can reach the
+	// listener.
+	v.Check(cfg.debug.addr == "" || cfg.debug.username != "" || isLoopbackAddr(cfg.debug.addr), "debug-username", "must be provided unless debug-addr is a loopback address")
+
+	v.Check(cfg.health.readyTimeout > 0, "ready-timeout", "must be greater than zero")
+	v.Check(cfg.health.maxJobBacklog >= 0, "ready-max-job-backlog", "must not be negative")
+	v.Check(cfg.health.drainDelay >= 0, "shutdown-drain-delay", "must not be negative")
+	v.Check(cfg.shutdown.timeout > 0, "shutdown-timeout", "must be greater than zero")
+	v.Check(cfg.health.drainDelay < cfg.shutdown.timeout, "shutdown-drain-delay", "must be less than shutdown-timeout")
+	v.Check(cfg.shutdown.httpTimeout > 0, "shutdown-http-timeout", "must be greater than zero")
+	v.Check(cfg.shutdown.taskTimeout > 0, "shutdown-task-timeout", "must be greater than zero")
+
+	v.Check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-key-file", "must be provided together with tls-cert-file")
+	v.Check(cfg.tls.reloadInterval >= 0, "tls-reload-interval", "must not be negative")
+	_, found := tlsVersions[cfg.tls.minVersion]
+	v.Check(found, "tls-min-version", "must be 1.2 or 1.3")
+	validateCipherSuites(v, cfg.tls.cipherSuites)
+	v.Check(cfg.tls.redirectAddr == "" || cfg.tls.certFile != "", "tls-redirect-addr", "requires tls-cert-file")
+	v.Check(cfg.tls.hstsMaxAge >= 0, "hsts-max-age", "must not be negative")
+
+	v.Check(cfg.log.sampleInterval >= 0, "log-sample-interval", "must not be negative")
+	v.Check(cfg.jobs.workers > 0, "jobs-workers", "must be greater than zero")
+	v.Check(cfg.jobs.pollInterval > 0, "jobs-poll-interval", "must be greater than zero")
+	v.Check(cfg.jobs.maxAttempts > 0, "jobs-max-attempts", "must be greater than zero")
+	v.Check(cfg.tokens.cleanupInterval >= 0, "tokens-cleanup-interval", "must not be negative")
+	v.Check(cfg.tokens.cleanupBatchSize > 0, "tokens-cleanup-batch-size", "must be greater than zero")
+
+	v.Check(validator.PermittedValue(cfg.tracing.exporter, "none", "stdout", "file"), "trace-exporter", "must be none, stdout or file")
+	v.Check(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "trace-sample-ratio", "must be between 0 and 1")
+}
+
+// isLoopbackAddr reports whether the listen address addr only accepts
+// connections from the local host. An empty host listens everywhere.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// stringsFlag is a space separated list.
+type stringsFlag struct {
+	dst *[]string
+}
+
+func (f stringsFlag) Set(val string) error {
+	*f.dst = strings.Fields(val)
+	return nil
+}
+
+func (f stringsFlag) String() string {
+	if f.dst == nil {
+		return ""
+	}
+	return strings.Join(*f.dst, " ")
+}
+
+func (f stringsFlag) Get() any {
+	return append([]string{}, *f.dst...)
+}
+
+type levelFlag struct {
+	dst *jsonlog.Level
+}
+
+func (f levelFlag) Set(val string) error {
+	level, err := jsonlog.ParseLevel(val)
+	if err != nil {
+		return err
+	}
+	*f.dst = level
+	return nil
+}
+
+func (f levelFlag) String() string {
+	if f.dst == nil {
+		return ""
+	}
+	return strings.ToLower(f.dst.String())
+}
+
+func (f levelFlag) Get() any {
+	return f.String()
+}
+
+// routesFlag sets the rate limit policy of one route each time it is given.
+type routesFlag struct {
+	dst map[string]ratelimit.Policy
+}
+
+func (f routesFlag) Set(val string) error {
+	route, policy, err := parseRoutePolicy(val)
+	if err != nil {
+		return err
+	}
+	f.dst[route] = policy
+	return nil
+}
+
+func (f routesFlag) Reset() {
+	clear(f.dst)
+}
+
+func (f routesFlag) Values() []string {
+	var values []string
+	for route, policy := range f.dst {
+		values = append(values, route+"="+formatPolicy(policy))
+	}
+	sort.Strings(values)
+	return values
+}
+
+func (f routesFlag) String() string {
+	return strings.Join(f.Values(), ", ")
+}
+
+// tiersFlag adds a permission's rate limit policy each time it is given.
+type tiersFlag struct {
+	dst *[]limiterTier
+}
+
+func (f tiersFlag) Set(val string) error {
+	permission, value, found := strings.Cut(val, "=")
+	if !found || permission == "" {
+		return errors.New(`must be in the form "code=rps:burst"`)
+	}
+	policy, err := parsePolicy(value)
+	if err != nil {
+		return err
+	}
+	*f.dst = append(*f.dst, limiterTier{permission: permission, policy: policy})
+	return nil
+}
+
+func (f tiersFlag) Reset() {
+	*f.dst = nil
+}
+
+func (f tiersFlag) Values() []string {
+	if f.dst == nil {
+		return nil
+	}
+	var values []string
+	for _, tier := range *f.dst {
+		values = append(values, tier.permission+"="+formatPolicy(tier.policy))
+	}
+	return values
+}
+
+func (f tiersFlag) String() string {
+	return strings.Join(f.Values(), ", ")
+}
+
+func formatPolicy(policy ratelimit.Policy) string {
+	return strconv.FormatFloat(policy.RPS, 'f', -1, 64) + ":" + strconv.Itoa(policy.Burst)
+}
@@ -9,9 +9,26 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	permissionsContextKey = contextKey("permissions")
+	clientIPContextKey    = contextKey("client_ip")
+	requestInfoContextKey = contextKey("request_info")
+	requestIDContextKey   = contextKey("request_id")
+	apiKeyContextKey      = contextKey("api_key")
+)
+
+// requestInfo is filled in by handlers running after routing for the benefit
+// of the outer middleware, which only see the request as it was before.
+type requestInfo struct {
+	route  string
+	userID int64
+}
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok && !user.IsAnonymous() {
+		info.userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -23,3 +40,67 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions returns the user's permissions if an earlier
+// middleware already loaded them for this request.
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+func (app *application) contextSetClientIP(r *http.Request, ip string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetClientIP(r *http.Request) string {
+	ip, ok := r.Context().Value(clientIPContextKey).(string)
+	if !ok {
+		panic("missing client ip value in request context")
+	}
+	return ip
+}
+
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		panic("missing request info value in request context")
+	}
+	return info
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns an empty string rather than panicking, as it is
+// also called from error responses written outside the middleware chain.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// contextSetAPIKey records the id of the API key the request authenticated
+// with, as returned by data.TokenID.
+func (app *application) contextSetAPIKey(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns an empty string for requests not made with an API
+// key.
+func (app *application) contextGetAPIKey(r *http.Request) string {
+	id, _ := r.Context().Value(apiKeyContextKey).(string)
+	return id
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/pprof"
+
+	"sulfur.test.net/internal/data/validator"
+	"sulfur.test.net/internal/mailer"
+)
+
+// debugRoutes serves the debugging and monitoring endpoints on the internal
+// listener started when -debug-addr is set.
+func (app *application) debugRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", app.instruments.registry.Handler)
+	mux.HandleFunc("/debug/mail", app.mailPreviewHandler)
+	mux.HandleFunc("/debug/health/ready", app.readinessDetailsHandler)
+	mux.HandleFunc("/debug/config/reload", app.reloadConfigHandler)
+	if app.config.debug.pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if app.config.debug.username != "" {
+		return app.requireBasicAuth(mux.ServeHTTP)
+	}
+	return mux
+}
+
+// mailPreviewHandler renders an email template with its sample data, e.g.
+// /debug/mail?template=user_welcome.tmpl&locale=fr&format=text. Without a
+// template it lists the templates and their translations.
+func (app *application) mailPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	name := app.readString(qs, "template", "")
+	if name == "" {
+		err := app.writeJSON(w, r, http.StatusOK, envelope{"templates": app.mailer.Templates()}, nil)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	v := validator.New()
+	format := app.readString(qs, "format", "html")
+	v.Check(validator.PermittedValue(format, "html", "text", "eml"), "format", "must be html, text or eml")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	msg, err := app.mailer.Render("preview@example.com", name, app.readString(qs, "locale", ""), mailer.SampleData(name))
+	if err != nil {
+		switch {
+		case errors.Is(err, mailer.ErrUnknownTemplate):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	switch format {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, msg.HTMLBody)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, "Subject: "+msg.Subject+"\n"+msg.PlainBody)
+	case "eml":
+		w.Header().Set("Content-Type", "message/rfc822")
+		msg.WriteTo(w)
+	}
+}
+
+// requireDebugAccess guards debug endpoints mounted on the public router:
+// with -debug-username set they need HTTP basic auth, otherwise a user with
+// the given permission.
+func (app *application) requireDebugAccess(permission string, next http.HandlerFunc) http.HandlerFunc {
+	if app.config.debug.username != "" {
+		return app.requireBasicAuth(next)
+	}
+	return app.requirePermission(permission, next)
+}
+
+func (app *application) requireBasicAuth(next http.HandlerFunc) http.HandlerFunc {
+	// Comparing hashes keeps the comparison constant time regardless of the
+	// lengths of the supplied credentials.
+	expectedUsername := sha256.Sum256([]byte(app.config.debug.username))
+	expectedPassword := sha256.Sum256([]byte(app.config.debug.password))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if ok {
+			usernameHash := sha256.Sum256([]byte(username))
+			passwordHash := sha256.Sum256([]byte(password))
+			usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsername[:]) == 1
+			passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPassword[:]) == 1
+			if usernameMatch && passwordMatch {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="debug", charset="UTF-8"`)
+		app.invalidCredentialResponse(w, r)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
+
+	"sulfur.test.net/internal/tracing"
 )
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
@@ -36,10 +38,19 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorRespone(w, r, http.StatusBadRequest, err.Error())
 }
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	properties := map[string]any{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
-	})
+	}
+	if id := app.contextGetRequestID(r); id != "" {
+		properties["request_id"] = id
+	}
+	span := tracing.SpanFromContext(r.Context())
+	if sc := span.Context(); sc.IsValid() {
+		properties["trace_id"] = sc.TraceID.String()
+	}
+	span.RecordError(err)
+	app.logger.PrintError(err, properties)
 }
 func (app *application) invalidCredentialResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
@@ -47,7 +58,10 @@ func (app *application) invalidCredentialResponse(w http.ResponseWriter, r *http
 }
 func (app *application) errorRespone(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := envelope{"error": message}
-	err := app.writeJSON(w, status, env, nil)
+	if id := app.contextGetRequestID(r); id != "" {
+		env["request_id"] = id
+	}
+	err := app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
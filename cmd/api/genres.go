@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"sulfur.test.net/internal/data"
+	"sulfur.test.net/internal/data/validator"
+)
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAllWithMovieCounts(r.Context())
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Slug    string   `json:"slug"`
+		Name    string   `json:"name"`
+		Aliases []string `json:"aliases"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	genre := &data.Genre{
+		Slug:    data.Slugify(input.Slug),
+		Name:    input.Name,
+		Aliases: []string{},
+	}
+	if genre.Slug == "" {
+		genre.Slug = data.Slugify(input.Name)
+	}
+	for _, alias := range input.Aliases {
+		genre.Aliases = append(genre.Aliases, data.Slugify(alias))
+	}
+	v := validator.New()
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	genres, err := app.models.Genres.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	for _, value := range append([]string{genre.Slug}, genre.Aliases...) {
+		if existing, found := genres.Canonical(value); found {
+			v.AddError("aliases", fmt.Sprintf("%q is already used by genre %q", value, existing))
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Insert(r.Context(), genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("slug", "a genre with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		// Another request added the same name since the check above.
+		case errors.Is(err, data.ErrDuplicateGenreName):
+			v.AddError("aliases", "a slug or alias is already used by another genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%s", genre.Slug))
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) mergeGenreHandler(w http.ResponseWriter, r *http.Request) {
+	source := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	var input struct {
+		Into string `json:"into"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(data.Slugify(input.Into) != "", "into", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Either genre may be named by an alias; movies must only ever be
+	// tagged with canonical slugs.
+	genres, err := app.models.Genres.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	source, found := genres.Canonical(source)
+	if !found {
+		app.notFoundResponse(w, r)
+		return
+	}
+	target, found := genres.Canonical(input.Into)
+	v.Check(found, "into", "must be an existing genre")
+	v.Check(!found || target != source, "into", "must be a different genre")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	moviesRewritten, err := app.models.Genres.Merge(r.Context(), source, target)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	env := envelope{
+		"message":          fmt.Sprintf("genre %q merged into %q", source, target),
+		"movies_rewritten": moviesRewritten,
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
@@ -1,19 +1,220 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sulfur.test.net/internal/migrate"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	status, code := "available", http.StatusOK
+	if app.shuttingDown.Load() {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
 	env := envelope{
-		"status": "available",
+		"status": status,
 		"system_info": map[string]string{
 			"environment": app.config.env,
 			"version":     version,
 		},
 	}
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeJSON(w, r, code, env, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// Check statuses, as in the draft RFC "Health Check Response Format for HTTP
+// APIs".
+const (
+	healthPass = "pass"
+	healthWarn = "warn"
+	healthFail = "fail"
+)
+
+type healthCheck struct {
+	Status     string  `json:"status"`
+	Output     string  `json:"output,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// livenessHandler only shows that the process is serving requests. It checks
+// no dependencies, so an outage elsewhere doesn't get the pod restarted.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"status": healthPass}, nil)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
 }
+
+// readyCacheTTL is how long readiness check results are reused, so probes
+// polling from many places cost at most one round of checks a second.
+const readyCacheTTL = time.Second
+
+// readinessCache holds the last readiness check results. Its mutex is held
+// while the checks run, so concurrent probes wait for one round of checks
+// rather than starting their own.
+type readinessCache struct {
+	mu      sync.Mutex
+	checked time.Time
+	results map[string]healthCheck
+}
+
+// readinessHandler reports whether this instance should receive traffic: it
+// fails with 503 while shutting down or when the database is unreachable or
+// on the wrong schema version. The SMTP server and the job backlog are shared
+// by every instance, so problems with them only warn rather than take all
+// instances out of rotation at once. The endpoint is public, so it only shows
+// each check's status; readinessDetailsHandler on the debug endpoints adds
+// the errors.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]healthCheck)
+	for name, check := range app.readinessChecks(r.Context()) {
+		check.Output = ""
+		checks[name] = check
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	app.writeReadiness(w, r, checks)
+}
+
+func (app *application) readinessDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	app.writeReadiness(w, r, app.readinessChecks(r.Context()))
+}
+
+// readinessChecks returns the readiness check results, running the checks
+// unless the cached results are younger than readyCacheTTL.
+func (app *application) readinessChecks(ctx context.Context) map[string]healthCheck {
+	if app.shuttingDown.Load() {
+		return map[string]healthCheck{
+			"shutdown": {Status: healthFail, Output: "server is shutting down"},
+		}
+	}
+	app.readiness.mu.Lock()
+	defer app.readiness.mu.Unlock()
+	if app.readiness.results != nil && time.Since(app.readiness.checked) < readyCacheTTL {
+		return app.readiness.results
+	}
+	// Other probes may be waiting for these results, so one that gives up
+	// mustn't cancel the checks.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), app.config.health.readyTimeout)
+	defer cancel()
+	app.readiness.results = app.runReadinessChecks(ctx)
+	app.readiness.checked = time.Now()
+	return app.readiness.results
+}
+
+func (app *application) runReadinessChecks(ctx context.Context) map[string]healthCheck {
+	checks := map[string]func(context.Context) (string, error){
+		"database":   app.checkDatabase,
+		"migrations": app.checkMigrations,
+	}
+	if app.config.health.checkSMTP && app.config.mail.transport == "smtp" {
+		checks["smtp"] = app.checkSMTP
+	}
+	if app.config.health.maxJobBacklog > 0 {
+		checks["jobs"] = app.checkJobBacklog
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]healthCheck, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) (string, error)) {
+			defer wg.Done()
+			start := time.Now()
+			status, err := check(ctx)
+			result := healthCheck{
+				Status:     status,
+				DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Output = err.Error()
+			}
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
+
+func (app *application) writeReadiness(w http.ResponseWriter, r *http.Request, checks map[string]healthCheck) {
+	status := healthPass
+	for _, check := range checks {
+		switch {
+		case check.Status == healthFail:
+			status = healthFail
+		case check.Status == healthWarn && status == healthPass:
+			status = healthWarn
+		}
+	}
+	code := http.StatusOK
+	if status == healthFail {
+		code = http.StatusServiceUnavailable
+	}
+	err := app.writeJSON(w, r, code, envelope{"status": status, "checks": checks}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) checkDatabase(ctx context.Context) (string, error) {
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		return healthFail, err
+	}
+	return healthPass, nil
+}
+
+// checkMigrations fails unless the schema is at the version this binary was
+// built for. A newer schema only warns: it's expected while a new release is
+// rolling out.
+func (app *application) checkMigrations(ctx context.Context) (string, error) {
+	want := app.migrator.Latest()
+	version, dirty, err := app.migrator.Version(ctx)
+	switch {
+	case err != nil:
+		return healthFail, err
+	case dirty:
+		return healthFail, fmt.Errorf("migration %d failed and left the schema dirty", version)
+	case version == migrate.NoVersion:
+		return healthFail, errors.New("no migrations have been applied")
+	case version < want:
+		return healthFail, fmt.Errorf("schema version %d is older than %d", version, want)
+	case version > want:
+		return healthWarn, fmt.Errorf("schema version %d is newer than %d", version, want)
+	}
+	return healthPass, nil
+}
+
+func (app *application) checkSMTP(ctx context.Context) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(app.config.smtp.host, strconv.Itoa(app.config.smtp.port)))
+	if err != nil {
+		return healthWarn, err
+	}
+	conn.Close()
+	return healthPass, nil
+}
+
+func (app *application) checkJobBacklog(ctx context.Context) (string, error) {
+	count, oldest, err := app.models.Jobs.Backlog(ctx)
+	if err != nil {
+		return healthWarn, err
+	}
+	if count > app.config.health.maxJobBacklog {
+		return healthWarn, fmt.Errorf("%d jobs waiting, the oldest for %s", count, oldest.Round(time.Second))
+	}
+	return healthPass, nil
+}
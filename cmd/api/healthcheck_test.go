@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessHandler_ShuttingDown(t *testing.T) {
+	app := &application{}
+	app.shuttingDown.Store(true)
+
+	w := httptest.NewRecorder()
+	app.readinessHandler(w, httptest.NewRequest(http.MethodGet, "/v1/healthcheck/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	var body struct {
+		Status string                 `json:"status"`
+		Checks map[string]healthCheck `json:"checks"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, healthFail, body.Status)
+	assert.Equal(t, healthFail, body.Checks["shutdown"].Status)
+
+	// Liveness doesn't change, so the pod isn't killed while it drains.
+	w = httptest.NewRecorder()
+	app.livenessHandler(w, httptest.NewRequest(http.MethodGet, "/v1/healthcheck/live", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestWriteReadiness(t *testing.T) {
+	tests := []struct {
+		checks map[string]healthCheck
+		status string
+		code   int
+	}{
+		{map[string]healthCheck{"database": {Status: healthPass}}, healthPass, http.StatusOK},
+		{map[string]healthCheck{"database": {Status: healthPass}, "jobs": {Status: healthWarn}}, healthWarn, http.StatusOK},
+		{map[string]healthCheck{"database": {Status: healthFail}, "jobs": {Status: healthWarn}}, healthFail, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		app := &application{}
+		w := httptest.NewRecorder()
+		app.writeReadiness(w, httptest.NewRequest(http.MethodGet, "/v1/healthcheck/ready", nil), tt.checks)
+		assert.Equal(t, tt.code, w.Code)
+		assert.Contains(t, w.Body.String(), `"status": "`+tt.status+`"`)
+	}
+}
+
+func TestReadinessHandler_Cached(t *testing.T) {
+	app := &application{}
+	app.readiness.results = map[string]healthCheck{
+		"database": {Status: healthFail, Output: "dial tcp 10.0.0.5:5432: connection refused"},
+	}
+	app.readiness.checked = time.Now()
+
+	// Cached results are served without running the checks, which would
+	// need a database.
+	w := httptest.NewRecorder()
+	app.readinessHandler(w, httptest.NewRequest(http.MethodGet, "/v1/healthcheck/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"fail"`)
+	assert.NotContains(t, w.Body.String(), "10.0.0.5", "errors aren't shown publicly")
+
+	w = httptest.NewRecorder()
+	app.readinessDetailsHandler(w, httptest.NewRequest(http.MethodGet, "/debug/health/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "10.0.0.5")
+}
@@ -1,32 +1,37 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"sulfur.test.net/internal/data/validator"
+	"sulfur.test.net/internal/tracing"
 )
 
 type envelope map[string]any
 
-func (app *application) background(fn func()) {
-	app.wg.Add(1)
+// background runs fn in a goroutine that shutdown waits for, with the root
+// context, which is cancelled when shutdown runs out of time.
+func (app *application) background(name string, fn func(ctx context.Context)) {
+	done := app.tasks.start(name)
 	go func() {
-		defer app.wg.Done()
+		defer done()
 
 		defer func() {
 			if err := recover(); err != nil {
 				app.logger.PrintError(fmt.Errorf("%s", err), nil)
 			}
 		}()
-		fn()
+		fn(app.tasks.context())
 	}()
 
 }
@@ -57,6 +62,59 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	}
 	return i
 }
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// readAcceptLanguage returns the primary language subtags from the
+// Accept-Language header ordered by preference, e.g. "ru-RU,en;q=0.8" gives
+// ["ru", "en"]. Languages with q=0 and the "*" wildcard are dropped.
+func (app *application) readAcceptLanguage(r *http.Request) []string {
+	type preference struct {
+		language string
+		quality  float64
+	}
+	var preferences []preference
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if language == "" || language == "*" {
+			continue
+		}
+		quality := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= 0 {
+			continue
+		}
+		preferences = append(preferences, preference{language, quality})
+	}
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].quality > preferences[j].quality
+	})
+	languages := []string{}
+	for _, p := range preferences {
+		if !validator.PermittedValue(p.language, languages...) {
+			languages = append(languages, p.language)
+		}
+	}
+	return languages
+}
+
 func (app *application) readIDParam(r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
@@ -110,8 +168,12 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	_, span := tracing.Start(r.Context(), "encode json")
 	js, err := json.MarshalIndent(data, "", "\t")
+	span.SetAttribute("bytes", len(js))
+	span.RecordError(err)
+	span.End()
 	if err != nil {
 		return err
 	}
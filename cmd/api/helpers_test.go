@@ -94,3 +94,11 @@ func TestReadJSON_MultipleValues(t *testing.T) {
 	assert.Error(t, err)
 	// assert.Contains(t, err.Error(), "body must contain a single json value")
 }
+
+func TestReadAcceptLanguage(t *testing.T) {
+	app := &application{}
+	req := httptest.NewRequest("GET", "/v1/movies", nil)
+	req.Header.Set("Accept-Language", "en;q=0.5, ru-RU, kk;q=0.8, de;q=0, *;q=0.1, ru")
+
+	assert.Equal(t, []string{"ru", "kk", "en"}, app.readAcceptLanguage(req))
+}
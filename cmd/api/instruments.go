@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+
+	"sulfur.test.net/internal/metrics"
+)
+
+// instruments are the Prometheus metrics served on /metrics. The expvar
+// counters published by the metrics middleware are kept alongside them.
+type instruments struct {
+	registry     *metrics.Registry
+	requests     *metrics.Vec
+	duration     *metrics.Histogram
+	inFlight     *metrics.Vec
+	rateLimited  *metrics.Vec
+	mail         *metrics.Vec
+	tokensPurged *metrics.Vec
+}
+
+func newInstruments(db *sql.DB) *instruments {
+	r := metrics.NewRegistry()
+	i := &instruments{
+		registry:     r,
+		requests:     r.NewCounter("http_requests_total", "HTTP requests served, by method, route pattern and status code.", "method", "route", "status"),
+		duration:     r.NewHistogram("http_request_duration_seconds", "HTTP request latency, by method and route pattern.", metrics.DefaultBuckets, "method", "route"),
+		inFlight:     r.NewGauge("http_requests_in_flight", "HTTP requests currently being served."),
+		rateLimited:  r.NewCounter("http_rate_limited_total", "Requests rejected by the rate limiter, by route.", "route"),
+		mail:         r.NewCounter("mailer_messages_total", "Emails the mailer attempted to send, by template and outcome.", "template", "outcome"),
+		tokensPurged: r.NewCounter("tokens_purged_total", "Expired tokens deleted by the cleanup worker."),
+	}
+
+	r.NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	r.NewGaugeFunc("db_open_connections", "Established connections, both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	r.NewGaugeFunc("db_in_use_connections", "Connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	r.NewGaugeFunc("db_idle_connections", "Idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	r.NewCounterFunc("db_wait_count_total", "Connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	r.NewCounterFunc("db_wait_duration_seconds_total", "Time spent waiting for a connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	r.NewCounterFunc("db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	r.NewCounterFunc("db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.", func() float64 {
+		return float64(db.Stats().MaxIdleTimeClosed)
+	})
+	r.NewCounterFunc("db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", func() float64 {
+		return float64(db.Stats().MaxLifetimeClosed)
+	})
+	return i
+}
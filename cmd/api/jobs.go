@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"sulfur.test.net/internal/data"
+	"sulfur.test.net/internal/data/validator"
+	"sulfur.test.net/internal/mailer"
+	"sulfur.test.net/internal/tracing"
+)
+
+const (
+	jobKindEmail = "email"
+
+	// jobLease is how long a worker may hold a job before another worker
+	// assumes it died and runs the job again. It must comfortably exceed the
+	// time any job takes.
+	jobLease = 5 * time.Minute
+
+	// jobRecordTimeout bounds recording a job's outcome, which still happens
+	// once shutdown has cancelled the root context.
+	jobRecordTimeout = 5 * time.Second
+)
+
+type emailJob struct {
+	Recipient string         `json:"recipient"`
+	Template  string         `json:"template"`
+	Locale    string         `json:"locale,omitempty"`
+	Data      map[string]any `json:"data"`
+	// Traceparent is the span that enqueued the job, so that sending the
+	// email shows up in the same trace as the request that caused it.
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// enqueueEmail queues an email to be sent by a job worker, so it is retried
+// until delivered even across restarts.
+func (app *application) enqueueEmail(ctx context.Context, recipient, templateFile, locale string, data map[string]any) error {
+	payload := newEmailJob(ctx, recipient, templateFile, locale, data)
+	_, err := app.models.Jobs.Enqueue(ctx, jobKindEmail, payload, app.config.jobs.maxAttempts)
+	if err != nil {
+		return err
+	}
+	app.wakeJobWorker()
+	return nil
+}
+
+// enqueueEmailTx is enqueueEmail as part of tx. The caller wakes a worker once
+// tx has committed, as the job can't be claimed before then.
+func (app *application) enqueueEmailTx(ctx context.Context, tx *sql.Tx, recipient, templateFile, locale string, data map[string]any) error {
+	payload := newEmailJob(ctx, recipient, templateFile, locale, data)
+	_, err := app.models.Jobs.EnqueueTx(ctx, tx, jobKindEmail, payload, app.config.jobs.maxAttempts)
+	return err
+}
+
+func newEmailJob(ctx context.Context, recipient, templateFile, locale string, data map[string]any) emailJob {
+	payload := emailJob{Recipient: recipient, Template: templateFile, Locale: locale, Data: data}
+	if sc := tracing.SpanFromContext(ctx).Context(); sc.IsValid() {
+		payload.Traceparent = sc.Traceparent()
+	}
+	return payload
+}
+
+// wakeJobWorker gets an idle worker to look for jobs now rather than at its
+// next poll.
+func (app *application) wakeJobWorker() {
+	select {
+	case app.jobsWake <- struct{}{}:
+	default:
+	}
+}
+
+// jobParent returns the span context stored in a job's payload by whoever
+// enqueued it, or an invalid one if there is none.
+func jobParent(job *data.Job) tracing.SpanContext {
+	var payload struct {
+		Traceparent string `json:"traceparent"`
+	}
+	if json.Unmarshal(job.Payload, &payload) != nil || payload.Traceparent == "" {
+		return tracing.SpanContext{}
+	}
+	sc, _ := tracing.ParseTraceparent(payload.Traceparent)
+	return sc
+}
+
+func (app *application) runJob(ctx context.Context, job *data.Job) error {
+	switch job.Kind {
+	case jobKindEmail:
+		var payload emailJob
+		err := json.Unmarshal(job.Payload, &payload)
+		if err != nil {
+			return err
+		}
+		return app.sendEmail(ctx, payload)
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
+
+// sendEmail sends an email job. Addresses that bounced or complained are
+// never mailed again, to protect our sender reputation; a suppressed
+// recipient completes the job without sending anything.
+func (app *application) sendEmail(ctx context.Context, payload emailJob) error {
+	err := app.mailer.Send(ctx, payload.Recipient, payload.Template, payload.Locale, payload.Data)
+	if errors.Is(err, mailer.ErrSuppressed) {
+		app.instruments.mail.Inc(payload.Template, "suppressed")
+		return nil
+	}
+	if err != nil {
+		app.instruments.mail.Inc(payload.Template, "failed")
+		return err
+	}
+	app.instruments.mail.Inc(payload.Template, "sent")
+	return nil
+}
+
+// jobBackoff returns the delay before retrying a job that has failed
+// attempts times: 30s, 1m, 2m and so on up to an hour, plus up to 10% jitter
+// so jobs that failed together don't all retry together.
+func jobBackoff(attempts int) time.Duration {
+	backoff := time.Hour
+	if attempts < 8 {
+		backoff = min(30*time.Second<<(max(attempts, 1)-1), time.Hour)
+	}
+	return backoff + time.Duration(rand.Int63n(int64(backoff/10)+1))
+}
+
+// startJobWorkers runs the configured number of job workers as background
+// tasks until ctx is cancelled. Workers stop claiming jobs then, but finish
+// the one they're running.
+func (app *application) startJobWorkers(ctx context.Context) {
+	for i := 0; i < app.config.jobs.workers; i++ {
+		app.background("job worker", func(context.Context) {
+			app.jobWorker(ctx)
+		})
+	}
+}
+
+func (app *application) jobWorker(ctx context.Context) {
+	for {
+		job, err := app.models.Jobs.Claim(ctx, jobLease)
+		switch {
+		case err == nil:
+			app.processJob(job)
+			continue
+		case ctx.Err() != nil:
+			return
+		case !errors.Is(err, data.ErrNoRecordFound):
+			app.logger.PrintError(err, map[string]any{"component": "jobs"})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-app.jobsWake:
+		case <-time.After(app.config.jobs.pollInterval):
+		}
+	}
+}
+
+// processJob runs a claimed job and records the outcome. It deliberately
+// uses the root context rather than the worker's, so a job in progress at
+// shutdown can finish unless shutdown runs out of time.
+func (app *application) processJob(job *data.Job) {
+	defer app.tasks.start(fmt.Sprintf("job %s %d", job.Kind, job.ID))()
+	ctx, span := app.tracer.Start(app.tasks.context(), "job "+job.Kind, jobParent(job))
+	defer span.End()
+	span.SetAttribute("job.id", job.ID)
+	span.SetAttribute("job.attempt", job.Attempts)
+
+	properties := map[string]any{
+		"job_id":   job.ID,
+		"kind":     job.Kind,
+		"attempts": job.Attempts,
+	}
+	err := func() (err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				err = fmt.Errorf("%s", rec)
+			}
+		}()
+		ctx, cancel := context.WithTimeout(ctx, jobLease)
+		defer cancel()
+		return app.runJob(ctx, job)
+	}()
+	// The job ran, so its outcome is recorded even if shutdown cancelled ctx
+	// meanwhile; otherwise it would run again once its lease expired.
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobRecordTimeout)
+	defer cancel()
+	if err == nil {
+		err = app.models.Jobs.Complete(recordCtx, job.ID)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+		}
+		return
+	}
+	span.RecordError(err)
+
+	status, failErr := app.models.Jobs.Fail(recordCtx, job.ID, err, jobBackoff(job.Attempts))
+	if failErr != nil {
+		app.logger.PrintError(failErr, properties)
+		return
+	}
+	properties["error"] = err
+	if status == data.JobDead {
+		app.logger.PrintError(errors.New("job failed permanently"), properties)
+		return
+	}
+	app.logger.PrintWarn("job failed, will retry", properties)
+}
+
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafeList = []string{"id", "run_at", "updated_at", "-id", "-run_at", "-updated_at"}
+	data.ValidateJobStatus(v, input.Status)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	jobs, metadata, err := app.models.Jobs.GetAll(r.Context(), input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"jobs": jobs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) showJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	job, err := app.models.Jobs.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// retryJobHandler requeues a dead job with a fresh set of attempts.
+func (app *application) retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	job, err := app.models.Jobs.Retry(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			app.retryConflictResponse(w, r, id)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	app.wakeJobWorker()
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) retryConflictResponse(w http.ResponseWriter, r *http.Request, id int64) {
+	_, err := app.models.Jobs.Get(r.Context(), id)
+	switch {
+	case err == nil:
+		app.errorRespone(w, r, http.StatusConflict, "only dead jobs can be retried")
+	case errors.Is(err, data.ErrNoRecordFound):
+		app.notFoundResponse(w, r)
+	default:
+		app.serverErrorRespone(w, r, err)
+	}
+}
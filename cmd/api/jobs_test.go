@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sulfur.test.net/internal/data"
+	"sulfur.test.net/internal/mailer"
+)
+
+func TestJobBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		backoff := jobBackoff(tt.attempts)
+		assert.GreaterOrEqual(t, backoff, tt.want, "attempts %d", tt.attempts)
+		assert.LessOrEqual(t, backoff, tt.want+tt.want/10, "attempts %d", tt.attempts)
+	}
+}
+
+// fakeSuppressions is a suppression list that can fail.
+type fakeSuppressions struct {
+	emails map[string]bool
+	err    error
+}
+
+func (s fakeSuppressions) Exists(ctx context.Context, email string) (bool, error) {
+	return s.emails[email], s.err
+}
+
+func TestRunJob_Email(t *testing.T) {
+	recorder := mailer.NewRecorder()
+	suppressions := &fakeSuppressions{emails: map[string]bool{"bob@example.com": true}}
+	mail, err := mailer.New(recorder, "no-reply@example.com", nil, suppressions)
+	assert.NoError(t, err)
+	app := &application{
+		mailer:      mail,
+		instruments: newInstruments(nil),
+	}
+	newJob := func(recipient string) *data.Job {
+		payload, err := json.Marshal(emailJob{
+			Recipient: recipient,
+			Template:  "user_welcome.tmpl",
+			Locale:    "fr-ca",
+			Data:      map[string]any{"activationToken": "TOKEN", "userID": 7},
+		})
+		assert.NoError(t, err)
+		return &data.Job{Kind: jobKindEmail, Payload: payload}
+	}
+
+	err = app.runJob(context.Background(), newJob("alice@example.com"))
+	assert.NoError(t, err)
+	messages := recorder.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "alice@example.com", messages[0].To)
+	assert.Equal(t, "Bienvenue sur Greenlight !", messages[0].Subject)
+	assert.Contains(t, messages[0].PlainBody, `{"token": "TOKEN"}`)
+	assert.Contains(t, messages[0].PlainBody, "est 7.")
+
+	err = app.runJob(context.Background(), newJob("bob@example.com"))
+	assert.NoError(t, err, "a suppressed recipient completes the job")
+	assert.Len(t, recorder.Messages(), 1, "nothing is sent to a suppressed recipient")
+
+	suppressions.err = errors.New("database unavailable")
+	err = app.runJob(context.Background(), newJob("alice@example.com"))
+	assert.Error(t, err, "the job is retried when suppressions can't be checked")
+	assert.Len(t, recorder.Messages(), 1)
+
+	err = app.runJob(context.Background(), &data.Job{Kind: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestJobParent(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	payload, err := json.Marshal(emailJob{Recipient: "alice@example.com", Traceparent: traceparent})
+	assert.NoError(t, err)
+	sc := jobParent(&data.Job{Kind: jobKindEmail, Payload: payload})
+	assert.True(t, sc.IsValid())
+	assert.Equal(t, traceparent, sc.Traceparent())
+
+	payload, err = json.Marshal(emailJob{Recipient: "alice@example.com"})
+	assert.NoError(t, err)
+	assert.False(t, jobParent(&data.Job{Kind: jobKindEmail, Payload: payload}).IsValid(), "jobs enqueued outside a trace")
+	assert.False(t, jobParent(&data.Job{Kind: jobKindEmail, Payload: []byte(`{"traceparent":"garbage"}`)}).IsValid())
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sulfur.test.net/internal/data"
+	"sulfur.test.net/internal/data/validator"
+)
+
+// webhookTolerance is how far a webhook's timestamp may be from our clock. A
+// captured request can't be replayed once it is older than this.
+const webhookTolerance = 5 * time.Minute
+
+// verifyWebhookSignature checks an X-Signature header of the form
+// "sha256=<hex HMAC-SHA256>" keyed with the webhook secret, over the
+// X-Signature-Timestamp header (Unix seconds), a ".", and the body. Requests
+// whose timestamp is outside webhookTolerance are rejected.
+func (app *application) verifyWebhookSignature(header, timestamp string, body []byte, now time.Time) bool {
+	sig, found := strings.CutPrefix(header, "sha256=")
+	if !found {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > webhookTolerance || age < -webhookTolerance {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(app.config.mail.webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// mailEventsHandler receives bounce and complaint notifications from the mail
+// provider. Permanent bounces and complaints suppress the address so no more
+// mail is sent to it; transient bounces are only logged.
+func (app *application) mailEventsHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.verifyWebhookSignature(r.Header.Get("X-Signature"), r.Header.Get("X-Signature-Timestamp"), body, time.Now()) {
+		app.errorRespone(w, r, http.StatusUnauthorized, "invalid or missing webhook signature")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var input struct {
+		Type        string `json:"type"`
+		BounceType  string `json:"bounce_type"`
+		Email       string `json:"email"`
+		Description string `json:"description"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(validator.PermittedValue(input.Type, data.SuppressionBounce, data.SuppressionComplaint), "type", "must be bounce or complaint")
+	if input.Type == data.SuppressionBounce {
+		v.Check(validator.PermittedValue(input.BounceType, "permanent", "transient"), "bounce_type", "must be permanent or transient")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	properties := map[string]any{"type": input.Type, "bounce_type": input.BounceType, "email": input.Email}
+	if input.Type == data.SuppressionBounce && input.BounceType == "transient" {
+		app.logger.PrintInfo("transient bounce", properties)
+		err = app.writeJSON(w, r, http.StatusOK, envelope{"suppressed": false}, nil)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	suppression := &data.Suppression{
+		Email:       input.Email,
+		Reason:      input.Type,
+		Description: input.Description,
+	}
+	err = app.models.Suppressions.Insert(r.Context(), suppression)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	app.logger.PrintWarn("email address suppressed", properties)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"suppressed": true}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
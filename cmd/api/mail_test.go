@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	var app application
+	app.config.mail.webhookSecret = "secret"
+	body := []byte(`{"type":"complaint","email":"alice@example.com"}`)
+	now := time.Unix(1_700_000_000, 0)
+	sign := func(timestamp string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	assert.True(t, app.verifyWebhookSignature(sign(timestamp, body), timestamp, body, now))
+	assert.True(t, app.verifyWebhookSignature(sign(timestamp, body), timestamp, body, now.Add(4*time.Minute)))
+	assert.False(t, app.verifyWebhookSignature(sign(timestamp, body), timestamp, body, now.Add(6*time.Minute)), "stale")
+	assert.False(t, app.verifyWebhookSignature(sign(timestamp, body), timestamp, body, now.Add(-6*time.Minute)), "from the future")
+	assert.False(t, app.verifyWebhookSignature(sign(timestamp, body), "1700000001", body, now), "timestamp changed")
+	assert.False(t, app.verifyWebhookSignature(sign(timestamp, body), timestamp, append(body, ' '), now), "body changed")
+	assert.False(t, app.verifyWebhookSignature(sign(timestamp, body), "", body, now), "no timestamp")
+	assert.False(t, app.verifyWebhookSignature("", timestamp, body, now), "no signature")
+}
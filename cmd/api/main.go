@@ -3,20 +3,30 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
+	"sulfur.test.net/internal/clientip"
 	"sulfur.test.net/internal/data"
 	"sulfur.test.net/internal/data/jsonlog"
+	"sulfur.test.net/internal/data/validator"
 	"sulfur.test.net/internal/mailer"
+	"sulfur.test.net/internal/migrate"
+	"sulfur.test.net/internal/ratelimit"
+	"sulfur.test.net/internal/storage"
+	"sulfur.test.net/internal/tracing"
 	"sulfur.test.net/internal/vcs"
+	"sulfur.test.net/migrations"
 )
 
 var (
@@ -24,18 +34,36 @@ var (
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	port            int
+	env             string
+	defaultLanguage string
+	db              struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		// migrateOnStart applies pending migrations before serving.
+		migrateOnStart bool
 	}
 	limiter struct {
 		rps     float64
 		burst   int
 		enabled bool
+		routes  map[string]ratelimit.Policy
+		tiers   []limiterTier
+		apiKey  ratelimit.Policy
+		backend string
+		timeout time.Duration
+	}
+	mail struct {
+		transport     string
+		outboxDir     string
+		webhookSecret string
+	}
+	dkim struct {
+		domain   string
+		selector string
+		keyFile  string
 	}
 	smtp struct {
 		host     string
@@ -47,61 +75,257 @@ type config struct {
 	cors struct {
 		trustedOrigings []string
 	}
+	posters struct {
+		maxBytes   int64
+		storageDir string
+		baseURL    string
+	}
+	log struct {
+		level            jsonlog.Level
+		traceLevel       jsonlog.Level
+		sampleInterval   time.Duration
+		sampleFirst      uint64
+		sampleThereafter uint64
+	}
+	jobs struct {
+		workers      int
+		pollInterval time.Duration
+		maxAttempts  int
+	}
+	shutdown struct {
+		timeout     time.Duration
+		httpTimeout time.Duration
+		taskTimeout time.Duration
+	}
+	tokens struct {
+		cleanupInterval  time.Duration
+		cleanupBatchSize int
+	}
+	tracing struct {
+		exporter    string
+		file        string
+		sampleRatio float64
+	}
+	tls struct {
+		certFile              string
+		keyFile               string
+		reloadInterval        time.Duration
+		minVersion            string
+		cipherSuites          []string
+		redirectAddr          string
+		hstsMaxAge            time.Duration
+		hstsIncludeSubdomains bool
+	}
+	health struct {
+		readyTimeout  time.Duration
+		checkSMTP     bool
+		maxJobBacklog int
+		drainDelay    time.Duration
+	}
+	trustedProxies []string
+	debug          struct {
+		addr     string
+		username string
+		password string
+		pprof    bool
+	}
+}
+
+// limiterTier grants users holding permission their own rate limit policy.
+type limiterTier struct {
+	permission string
+	policy     ratelimit.Policy
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config      config
+	logger      *jsonlog.Logger
+	clientIP    *clientip.Resolver
+	models      data.Models
+	mailer      mailer.Mailer
+	storage     storage.Storage
+	limiter     ratelimit.Limiter
+	instruments *instruments
+	tracer      *tracing.Tracer
+	jobsWake    chan struct{}
+	tasks       taskGroup
+	db          *sql.DB
+	migrator    *migrate.Migrator
+	// shuttingDown fails readiness checks from the moment shutdown begins.
+	shuttingDown atomic.Bool
+	readiness    readinessCache
+
+	// live is config as last reloaded; only the settings in reloadableFlags
+	// differ from config. configArgs and configValues are kept for reloads.
+	live         atomic.Pointer[config]
+	configArgs   []string
+	configValues map[string]string
+	reloadMu     sync.Mutex
 }
 
 func main() {
 
 	var cfg config
-	flag.IntVar(&cfg.port, "port", 4000, "Api server port")
-	flag.StringVar(&cfg.env, "env", "development", "Enviroment (development|staging|production)")
+	fs, cmd := newConfigFlagSet(&cfg, flag.ExitOnError)
+	configFile, err := loadConfig(fs, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		jsonlog.New(os.Stdout, jsonlog.LevelInfo).PrintFatal(err, nil)
+	}
 
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL dsn")
+	if *cmd.version {
+		fmt.Printf("Version:\t%s\n", version)
+		os.Exit(0)
+	}
+
+	v := validator.New()
+	if validateConfig(v, cfg); !v.Valid() {
+		properties := make(map[string]any, len(v.Errors))
+		for key, message := range v.Errors {
+			properties[key] = message
+		}
+		jsonlog.New(os.Stdout, jsonlog.LevelInfo).PrintFatal(errors.New("invalid configuration"), properties)
+	}
 
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	if *cmd.printConfig {
+		err = printConfig(os.Stdout, fs)
+		if err != nil {
+			jsonlog.New(os.Stdout, jsonlog.LevelInfo).PrintFatal(err, nil)
+		}
+		os.Exit(0)
+	}
+
+	logger := jsonlog.New(os.Stdout, cfg.log.level)
+	logger.SetTraceLevel(cfg.log.traceLevel)
+	logger.SetSampling(cfg.log.sampleInterval, cfg.log.sampleFirst, cfg.log.sampleThereafter)
+	if configFile != "" {
+		logger.PrintInfo("configuration loaded", map[string]any{"file": configFile})
+	}
 
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter per seconds")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 2, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	resolver, err := clientip.New(cfg.trustedProxies)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+	logger.PrintInfo("database connection established", nil)
 
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "209a14c24fb89e", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "6da2405eaedd26", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.alexedwards.net>", "SMTP sender")
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	migrator.OnStep = func(step migrate.Step) {
+		logger.PrintInfo("applying migration", map[string]any{"migration": step.String()})
+	}
+	args := fs.Args()
+	if len(args) > 0 && args[0] == "migrate" {
+		err = runMigrate(context.Background(), migrator, logger, args[1:])
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+	if cfg.db.migrateOnStart {
+		err = migrator.Up(context.Background())
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+	err = checkSchema(context.Background(), migrator, logger)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigings = strings.Fields(val)
-		return nil
-	})
+	if len(args) > 0 {
+		err = runAdmin(context.Background(), data.NewModels(db), os.Stdin, os.Stdout, args)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			if writeAdminError(os.Stdout, err) {
+				os.Exit(1)
+			}
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
 
-	displayVersion := flag.Bool("version", false, "Display version and exit")
+	store, err := storage.NewLocal(cfg.posters.storageDir, cfg.posters.baseURL)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
-	flag.Parse()
+	models := data.NewModels(db)
 
-	if *displayVersion {
-		fmt.Printf("Version:\t%s\n", version)
-		os.Exit(0)
+	var limiter ratelimit.Limiter
+	switch cfg.limiter.backend {
+	case "memory":
+		limiter = ratelimit.NewMemory()
+	case "postgres":
+		limiterLogger := logger.With(map[string]any{"component": "ratelimit"})
+		limiter = ratelimit.NewShared(models.RateLimits, cfg.limiter.timeout, func(err error) {
+			limiterLogger.PrintWarn(err.Error(), nil)
+		})
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown rate limiter backend %q", cfg.limiter.backend), nil)
 	}
+	defer limiter.Close()
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	var transport mailer.Transport
+	switch cfg.mail.transport {
+	case "smtp":
+		transport = mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password)
+	case "outbox":
+		transport, err = mailer.NewOutbox(cfg.mail.outboxDir)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	case "log":
+		transport = mailer.NewLog(logger.With(map[string]any{"component": "mailer"}))
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown mail transport %q", cfg.mail.transport), nil)
+	}
 
-	db, err := openDB(cfg)
+	var signer *mailer.DKIMSigner
+	if cfg.dkim.keyFile != "" {
+		key, err := os.ReadFile(cfg.dkim.keyFile)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		signer, err = mailer.NewDKIMSigner(cfg.dkim.domain, cfg.dkim.selector, key)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
 
+	mail, err := mailer.New(transport, cfg.smtp.sender, signer, models.Suppressions)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
-	defer db.Close()
-	logger.PrintInfo("database connection established", nil)
+
+	var exporter tracing.Exporter
+	switch cfg.tracing.exporter {
+	case "none":
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		exporter, err = tracing.NewFileExporter(cfg.tracing.file)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown trace exporter %q", cfg.tracing.exporter), nil)
+	}
+	var tracer *tracing.Tracer
+	if exporter != nil {
+		tracingLogger := logger.With(map[string]any{"component": "tracing"})
+		tracer = tracing.New(exporter, cfg.tracing.sampleRatio, func(err error) {
+			tracingLogger.PrintWarn(err.Error(), nil)
+		})
+	}
 
 	expvar.NewString("version").Set(version)
 	expvar.Publish("goroutines", expvar.Func(func() any {
@@ -114,11 +338,24 @@ func main() {
 		return time.Now().Unix()
 	}))
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:       cfg,
+		logger:       logger,
+		clientIP:     resolver,
+		models:       models,
+		mailer:       mail,
+		storage:      store,
+		limiter:      limiter,
+		instruments:  newInstruments(db),
+		tracer:       tracer,
+		jobsWake:     make(chan struct{}, 1),
+		db:           db,
+		migrator:     migrator,
+		configArgs:   os.Args[1:],
+		configValues: flagValues(fs),
 	}
+	// A copy, so that nothing in live is ever written after it's stored.
+	live := cfg
+	app.live.Store(&live)
 
 	err = app.serve()
 	if err != nil {
@@ -126,6 +363,37 @@ func main() {
 	}
 }
 
+func parsePolicy(val string) (ratelimit.Policy, error) {
+	rps, burst, found := strings.Cut(val, ":")
+	if !found {
+		return ratelimit.Policy{}, errors.New(`rate limit policy must be in the form "rps:burst"`)
+	}
+	var policy ratelimit.Policy
+	var err error
+	policy.RPS, err = strconv.ParseFloat(rps, 64)
+	if err != nil || policy.RPS <= 0 {
+		return ratelimit.Policy{}, fmt.Errorf("invalid requests per second %q", rps)
+	}
+	policy.Burst, err = strconv.Atoi(burst)
+	if err != nil || policy.Burst < 1 {
+		return ratelimit.Policy{}, fmt.Errorf("invalid burst %q", burst)
+	}
+	return policy, nil
+}
+
+func parseRoutePolicy(val string) (string, ratelimit.Policy, error) {
+	route, value, found := strings.Cut(val, "=")
+	method, pattern, hasPattern := strings.Cut(strings.TrimSpace(route), " ")
+	if !found || !hasPattern || method == "" || !strings.HasPrefix(pattern, "/") {
+		return "", ratelimit.Policy{}, errors.New(`must be in the form "METHOD /pattern=rps:burst"`)
+	}
+	policy, err := parsePolicy(value)
+	if err != nil {
+		return "", ratelimit.Policy{}, err
+	}
+	return strings.ToUpper(method) + " " + pattern, policy, nil
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -1,20 +1,22 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
+	"math"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/felixge/httpsnoop"
-	"github.com/tomasen/realip"
-	"golang.org/x/time/rate"
 	"sulfur.test.net/internal/data"
 	"sulfur.test.net/internal/data/validator"
+	"sulfur.test.net/internal/ratelimit"
+	"sulfur.test.net/internal/tracing"
 )
 
 func (app *application) metrics(next http.Handler) http.Handler {
@@ -25,10 +27,136 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		totalRequestReceived.Add(1)
+		app.instruments.inFlight.Inc()
 		metrics := httpsnoop.CaptureMetrics(next, w, r)
+		app.instruments.inFlight.Dec()
 		totalResponsesSent.Add(1)
 		totalProcessingTimeMicroSeconds.Add(metrics.Duration.Microseconds())
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+
+		route := app.contextGetRequestInfo(r).route
+		if route == "" {
+			route = "unmatched"
+		}
+		app.instruments.requests.Inc(r.Method, route, strconv.Itoa(metrics.Code))
+		app.instruments.duration.Observe(metrics.Duration.Seconds(), r.Method, route)
+	})
+}
+
+func (app *application) trackRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = app.contextSetRequestInfo(r, &requestInfo{})
+		next.ServeHTTP(w, r)
+	})
+}
+
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// logRequest writes one access log line per request. It reuses the
+// X-Request-ID sent by a client or proxy when it looks sane and generates one
+// otherwise; either way the ID is echoed in the response and added to error
+// logs and error bodies so complaints can be matched to log entries.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	// Every access log has the same message, so sampling would drop nearly
+	// all of them.
+	accessLog := app.logger.Unsampled()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorRespone(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		info := app.contextGetRequestInfo(r)
+		properties := map[string]any{
+			"request_id":  id,
+			"method":      r.Method,
+			"uri":         r.URL.RequestURI(),
+			"route":       info.route,
+			"status":      metrics.Code,
+			"bytes":       metrics.Written,
+			"duration_ms": float64(metrics.Duration.Microseconds()) / 1000,
+			"client_ip":   app.contextGetClientIP(r),
+		}
+		if info.userID != 0 {
+			properties["user_id"] = info.userID
+		}
+		if sc := tracing.SpanFromContext(r.Context()).Context(); sc.IsValid() {
+			properties["trace_id"] = sc.TraceID.String()
+		}
+		accessLog.PrintInfo("request", properties)
+	})
+}
+
+// recordRoute notes the matched route pattern, e.g. /v1/movies/:id, so that
+// metrics aren't labelled with raw paths.
+func (app *application) recordRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.contextGetRequestInfo(r).route = pattern
+		next.ServeHTTP(w, r)
+	})
+}
+
+// traceRequest starts the server span for a request, continuing the caller's
+// trace when it sends a W3C traceparent header. Once the request has been
+// handled the span is renamed after the matched route.
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	if app.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, _ := tracing.Extract(r.Header)
+		ctx, span := app.tracer.Start(r.Context(), r.Method, remote)
+		defer span.End()
+		span.SetKind("server")
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("client.address", app.contextGetClientIP(r))
+		w.Header().Set("traceresponse", span.Context().Traceparent())
+
+		metrics := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+
+		info := app.contextGetRequestInfo(r)
+		if info.route != "" {
+			span.SetName(r.Method + " " + info.route)
+			span.SetAttribute("http.route", info.route)
+		}
+		if info.userID != 0 {
+			span.SetAttribute("user.id", info.userID)
+		}
+		span.SetAttribute("http.response.status_code", metrics.Code)
+		if metrics.Code >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(metrics.Code)))
+		}
+	})
+}
+
+// traceStage wraps the rest of the chain in a span named after the middleware
+// or handler about to run, so time spent in each stage shows up in the trace.
+func (app *application) traceStage(name string, next http.Handler) http.Handler {
+	if app.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), name)
+		defer span.End()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (app *application) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = app.contextSetClientIP(r, app.clientIP.ClientIP(r))
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -42,8 +170,9 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		origin := r.Header.Get("Origin")
 
 		if origin != "" {
-			for i := range app.config.cors.trustedOrigings {
-				if origin == app.config.cors.trustedOrigings[i] {
+			trustedOrigins := app.live.Load().cors.trustedOrigings
+			for i := range trustedOrigins {
+				if origin == trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
@@ -63,7 +192,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, r, err := app.loadPermissions(r, user)
 		if err != nil {
 			app.serverErrorRespone(w, r, err)
 			return
@@ -113,27 +242,47 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
+		// Basic credentials are only used by the debug endpoints, which check
+		// them themselves.
+		if len(headerParts) == 2 && headerParts[0] == "Basic" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(headerParts) != 2 {
+			app.authenticationFailed(w, r)
+			return
+		}
+		var scope string
+		switch headerParts[0] {
+		case "Bearer":
+			scope = data.ScopeAuthentication
+		case "ApiKey":
+			scope = data.ScopeAPIKey
+		default:
+			app.authenticationFailed(w, r)
 			return
 		}
 		token := headerParts[1]
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
-			app.invalidAuthenticationTokenResponse(w, r)
+			app.authenticationFailed(w, r)
 			return
 		}
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), scope, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrNoRecordFound):
-				app.invalidAuthenticationTokenResponse(w, r)
+				app.authenticationFailed(w, r)
 			default:
 				app.serverErrorRespone(w, r, err)
 			}
 			return
 		}
+		if scope == data.ScopeAPIKey {
+			r = app.contextSetAPIKey(r, data.TokenID(token))
+		}
 		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
@@ -150,48 +299,123 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+
+// scalePolicy applies a tier to policy. Tiers are written as absolute
+// policies, and what they grant is their ratio to the global policy, base, so
+// a tier with twice the global RPS also doubles a route's own policy.
+func scalePolicy(policy, base, tier ratelimit.Policy) ratelimit.Policy {
+	if base.RPS > 0 {
+		policy.RPS *= tier.RPS / base.RPS
 	}
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			mu.Unlock()
+	if base.Burst > 0 {
+		policy.Burst = max(1, int(math.Round(float64(policy.Burst)*float64(tier.Burst)/float64(base.Burst))))
+	}
+	return policy
+}
+
+// loadPermissions returns the user's permissions, loading them at most once
+// per request for the rate limiter and requirePermission to share.
+func (app *application) loadPermissions(r *http.Request, user *data.User) (data.Permissions, *http.Request, error) {
+	if permissions, ok := app.contextGetPermissions(r); ok {
+		return permissions, r, nil
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return nil, r, err
+	}
+	return permissions, app.contextSetPermissions(r, permissions), nil
+}
+
+// authenticationFailed rejects a request with bad credentials. No user was
+// identified, so the failure counts against the client IP's rate limit like
+// anonymous traffic; otherwise tokens could be guessed as fast as the
+// database answers.
+func (app *application) authenticationFailed(w http.ResponseWriter, r *http.Request) {
+	limits := app.live.Load().limiter
+	if limits.enabled {
+		result, err := app.limiter.Allow("ip:"+app.contextGetClientIP(r), ratelimit.Policy{RPS: limits.rps, Burst: limits.burst})
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
 		}
-	}()
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
+		if !result.Allowed {
+			app.instruments.rateLimited.Inc("authenticate")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			app.rateLimitExceedResponse(w, r)
+			return
+		}
+	}
+	app.invalidAuthenticationTokenResponse(w, r)
+}
 
-			ip := realip.FromRequest(r)
-			mu.Lock()
-			if _, found := clients[ip]; !found {
-				// Create and add a new client struct to the map if it doesn't already exist.
-				clients[ip] = &client{
+// rateLimit enforces the policy for route, a "METHOD /pattern" string or ""
+// for requests that didn't match any route. It runs inside authenticate so
+// authenticated users are limited by user ID rather than by IP address, which
+// keeps partners sharing one NAT from exhausting each other's budget.
+func (app *application) rateLimit(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// One snapshot for the whole request, as SIGHUP may replace it.
+		limits := app.live.Load().limiter
+		if !limits.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		base := ratelimit.Policy{RPS: limits.rps, Burst: limits.burst}
+		policy := base
+		routePolicy, hasRoutePolicy := limits.routes[route]
+		if hasRoutePolicy {
+			policy = routePolicy
+		}
 
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+		key := "ip:" + app.contextGetClientIP(r)
+		user := app.contextGetUser(r)
+		if !user.IsAnonymous() {
+			key = "user:" + strconv.FormatInt(user.ID, 10)
+			tier := base
+			// Each API key gets its own bucket, so one integration cannot use
+			// up its owner's limit, and its own policy, which a permission
+			// tier may still raise.
+			if id := app.contextGetAPIKey(r); id != "" {
+				key = "apikey:" + id
+				tier = limits.apiKey
+			}
+			if len(limits.tiers) > 0 {
+				var permissions data.Permissions
+				var err error
+				permissions, r, err = app.loadPermissions(r, user)
+				if err != nil {
+					app.serverErrorRespone(w, r, err)
+					return
+				}
+				for _, t := range limits.tiers {
+					if permissions.Include(t.permission) && t.policy.RPS > tier.RPS {
+						tier = t.policy
+					}
 				}
 			}
-			// Update the last seen time for the client.
-			clients[ip].lastSeen = time.Now()
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceedResponse(w, r)
-				return
+			policy = scalePolicy(policy, base, tier)
+		}
+		if hasRoutePolicy {
+			key = route + "|" + key
+		}
+
+		result, err := app.limiter.Allow(key, policy)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+		if !result.Allowed {
+			if route == "" {
+				app.instruments.rateLimited.Inc("unmatched")
+			} else {
+				app.instruments.rateLimited.Inc(route)
 			}
-			mu.Unlock()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			app.rateLimitExceedResponse(w, r)
+			return
 		}
 		next.ServeHTTP(w, r)
 	})
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sulfur.test.net/internal/data"
+	"sulfur.test.net/internal/data/jsonlog"
+	"sulfur.test.net/internal/ratelimit"
+)
+
+func TestScalePolicy(t *testing.T) {
+	base := ratelimit.Policy{RPS: 2, Burst: 4}
+	partner := ratelimit.Policy{RPS: 20, Burst: 40}
+
+	assert.Equal(t, partner, scalePolicy(base, base, partner), "no route policy")
+	assert.Equal(t, ratelimit.Policy{RPS: 100, Burst: 200}, scalePolicy(ratelimit.Policy{RPS: 10, Burst: 20}, base, partner))
+	assert.Equal(t, ratelimit.Policy{RPS: 10, Burst: 20}, scalePolicy(ratelimit.Policy{RPS: 10, Burst: 20}, base, base), "no tier")
+}
+
+func TestAuthenticationFailed(t *testing.T) {
+	app := &application{
+		logger:      jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		limiter:     ratelimit.NewMemory(),
+		instruments: newInstruments(nil),
+	}
+	var cfg config
+	cfg.limiter.enabled = true
+	cfg.limiter.rps = 0.1
+	cfg.limiter.burst = 2
+	app.live.Store(&cfg)
+	handler := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called")
+	}))
+
+	codes := []int{}
+	for i := 0; i < 4; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.Header.Set("Authorization", "Bearer tooshort")
+		r = app.contextSetClientIP(r, "192.0.2.1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{401, 401, 429, 429}, codes)
+}
+
+func TestRateLimitAPIKey(t *testing.T) {
+	app := &application{
+		logger:      jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		limiter:     ratelimit.NewMemory(),
+		instruments: newInstruments(nil),
+	}
+	var cfg config
+	cfg.limiter.enabled = true
+	cfg.limiter.rps = 0.1
+	cfg.limiter.burst = 1
+	cfg.limiter.apiKey = ratelimit.Policy{RPS: 0.1, Burst: 2}
+	app.live.Store(&cfg)
+	handler := app.rateLimit("GET /v1/movies", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	user := &data.User{ID: 1}
+	send := func(apiKey string) (int, string) {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r = app.contextSetClientIP(r, "192.0.2.1")
+		r = app.contextSetUser(r, user)
+		if apiKey != "" {
+			r = app.contextSetAPIKey(r, apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code, w.Header().Get("RateLimit-Limit")
+	}
+
+	code, limit := send("")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, "1", limit, "users without a key get the global policy")
+	code, _ = send("")
+	assert.Equal(t, 429, code)
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		code, limit = send("key-a")
+		codes = append(codes, code)
+	}
+	assert.Equal(t, []int{200, 200, 429}, codes, "a key has its own bucket and policy")
+	assert.Equal(t, "2", limit)
+
+	code, _ = send("key-b")
+	assert.Equal(t, 200, code, "each key has its own bucket")
+}
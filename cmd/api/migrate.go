@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"sulfur.test.net/internal/data/jsonlog"
+	"sulfur.test.net/internal/migrate"
+)
+
+// runMigrate runs a "migrate" command: up, down [N], goto VERSION, version or
+// force VERSION.
+func runMigrate(ctx context.Context, migrator *migrate.Migrator, logger *jsonlog.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New("migrate: missing command (up|down|goto|version|force)")
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "up":
+		if len(args) != 0 {
+			return errors.New("migrate up takes no arguments")
+		}
+		return migrator.Up(ctx)
+	case "down":
+		n := 1
+		if len(args) == 1 {
+			var err error
+			n, err = strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("migrate down: %q is not a positive number", args[0])
+			}
+		} else if len(args) > 1 {
+			return errors.New("migrate down takes at most one argument")
+		}
+		return migrator.Down(ctx, n)
+	case "goto", "force":
+		if len(args) != 1 {
+			return fmt.Errorf("migrate %s takes a version", command)
+		}
+		version, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("migrate %s: %q is not a version", command, args[0])
+		}
+		if command == "force" {
+			return migrator.Force(ctx, version)
+		}
+		return migrator.Goto(ctx, version)
+	case "version":
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		logger.PrintInfo("schema version", map[string]any{
+			"version": version,
+			"dirty":   dirty,
+			"latest":  migrator.Latest(),
+		})
+		return nil
+	}
+	return fmt.Errorf("migrate: unknown command %q", command)
+}
+
+// checkSchema refuses to serve against a schema older than the migrations
+// built in, or one a failed migration left dirty. A newer schema is allowed
+// so that a release can be rolled back without reverting its migrations.
+func checkSchema(ctx context.Context, migrator *migrate.Migrator, logger *jsonlog.Logger) error {
+	version, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	latest := migrator.Latest()
+	switch {
+	case dirty:
+		return fmt.Errorf("%w (version %d)", migrate.ErrDirty, version)
+	case version < latest:
+		return fmt.Errorf("schema version %d is older than %d; run \"migrate up\" or start with -migrate-on-start", version, latest)
+	case version > latest:
+		logger.PrintWarn("schema is newer than this build", map[string]any{"version": version, "latest": latest})
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"sulfur.test.net/internal/data"
 	"sulfur.test.net/internal/data/validator"
@@ -13,36 +14,80 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	var input struct {
 		Title  string
 		Genres []string
+		Fuzzy  bool
+		Facets []string
 		data.Filters
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 	input.Title = app.readString(qs, "tittle", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	if input.Fuzzy {
+		input.Filters.Sort = app.readString(qs, "sort", "-similarity")
+		input.Filters.SortSafeList = append(input.Filters.SortSafeList, "similarity", "-similarity")
+	}
+	data.ValidateFacets(v, input.Facets)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	if len(input.Genres) > 0 {
+		genres, err := app.models.Genres.GetAll(r.Context())
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+		for i := range input.Genres {
+			slug, ok := genres.Canonical(input.Genres[i])
+			if !ok {
+				v.AddError("genres", fmt.Sprintf("unknown genre %q", input.Genres[i]))
+				continue
+			}
+			input.Genres[i] = slug
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Fuzzy, input.Filters)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	err = app.localize(w, r, movies...)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	app.setPosterURLs(movies...)
+	env := envelope{"movies": movies, "metadata": metadata}
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Movies.GetFacets(r.Context(), input.Title, input.Genres, input.Fuzzy, input.Facets)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
 }
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title    string       `json:"title"`
+		Synopsis string       `json:"synopsis"`
+		Year     int32        `json:"year"`
+		Runtime  data.Runtime `json:"runtime"`
+		Genres   []string     `json:"genres"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -50,25 +95,31 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:    input.Title,
+		Synopsis: input.Synopsis,
+		Year:     input.Year,
+		Runtime:  input.Runtime,
+		Genres:   input.Genres,
+	}
+	genres, err := app.models.Genres.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
 	}
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
 	}
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
@@ -80,7 +131,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		app.notFoundResponse(w, r)
 	}
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecordFound):
@@ -91,10 +142,11 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+		Title    *string       `json:"title"`
+		Synopsis *string       `json:"synopsis"`
+		Year     *int32        `json:"year"`
+		Runtime  *data.Runtime `json:"runtime"`
+		Genres   []string      `json:"genres"`
 	}
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -105,36 +157,69 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if input.Title != nil {
 		movie.Title = *input.Title
 	}
+	if input.Synopsis != nil {
+		movie.Synopsis = *input.Synopsis
+	}
 	if input.Runtime != nil {
 		movie.Runtime = *input.Runtime
 	}
 	if input.Genres != nil {
 		movie.Genres = input.Genres
 	}
+	genres, err := app.models.Genres.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(r.Context(), movie)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, nil)
+	app.setPosterURLs(movie)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
 
 }
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+	prefix := strings.TrimSpace(app.readString(qs, "q", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+	v.Check(prefix != "", "q", "must be provided")
+	v.Check(len(prefix) <= 100, "q", "must not to be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	suggestions, err := app.models.Movies.Suggest(r.Context(), prefix, limit)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecordFound):
@@ -145,8 +230,15 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	err = app.localize(w, r, movie)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Language", movie.Language)
+	app.setPosterURLs(movie)
 	// Encode the struct to JSON and send it as the HTTP response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
@@ -159,7 +251,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecordFound):
@@ -169,7 +261,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie succesfuly deleted"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie succesfuly deleted"}, nil)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"sulfur.test.net/internal/data"
+	"sulfur.test.net/internal/data/validator"
+	"sulfur.test.net/internal/imaging"
+	"sulfur.test.net/internal/storage"
+)
+
+var posterSizes = map[string]int{
+	"small":  200,
+	"medium": 400,
+	"large":  800,
+}
+
+func posterSizeKey(key, size string) string {
+	ext := path.Ext(key)
+	return strings.TrimSuffix(key, ext) + "_" + size + ext
+}
+
+func (app *application) setPosterURLs(movies ...*data.Movie) {
+	for _, movie := range movies {
+		if movie.PosterKey == "" {
+			continue
+		}
+		movie.Poster = map[string]string{"original": app.storage.URL(movie.PosterKey)}
+		if path.Ext(movie.PosterKey) == imaging.Extension(imaging.WebP) {
+			continue
+		}
+		for size := range posterSizes {
+			movie.Poster[size] = app.storage.URL(posterSizeKey(movie.PosterKey, size))
+		}
+	}
+}
+
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.posters.maxBytes)
+	file, _, err := r.FormFile("poster")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not to be larger than %d bytes", maxBytesError.Limit))
+		case errors.Is(err, http.ErrMissingFile):
+			app.badRequestResponse(w, r, errors.New("body must contain a multipart file field named poster"))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	original, err := io.ReadAll(file)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	v := validator.New()
+	contentType, err := imaging.Sniff(original)
+	if err != nil {
+		v.AddError("poster", "must be a JPEG, PNG or WebP image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suffix := make([]byte, 6)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	key := fmt.Sprintf("movies/%d/%d-%s%s", movie.ID, time.Now().Unix(), hex.EncodeToString(suffix), imaging.Extension(contentType))
+
+	thumbnails := make(map[string][]byte)
+	if imaging.CanResize(contentType) {
+		img, err := imaging.Decode(bytes.NewReader(original), contentType)
+		if err != nil {
+			v.AddError("poster", "must be a valid image no larger than 40 megapixels")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		// Convert the upload once, then make each size from the next larger
+		// one, so only the first resize reads the full size image.
+		sizes := make([]string, 0, len(posterSizes))
+		for size := range posterSizes {
+			sizes = append(sizes, size)
+		}
+		sort.Slice(sizes, func(i, j int) bool {
+			return posterSizes[sizes[i]] > posterSizes[sizes[j]]
+		})
+		resized := imaging.RGBA(img)
+		for _, size := range sizes {
+			resized = imaging.Resize(resized, posterSizes[size])
+			buf := new(bytes.Buffer)
+			err = imaging.Encode(buf, resized, contentType)
+			if err != nil {
+				app.serverErrorRespone(w, r, err)
+				return
+			}
+			thumbnails[posterSizeKey(key, size)] = buf.Bytes()
+		}
+	}
+
+	// Whatever was stored is deleted again unless the movie ends up pointing
+	// at it.
+	var stored []string
+	saved := false
+	defer func() {
+		if saved {
+			return
+		}
+		for _, k := range stored {
+			err := app.storage.Delete(k)
+			if err != nil {
+				app.logger.PrintError(err, map[string]any{"key": k})
+			}
+		}
+	}()
+	err = app.storage.Put(key, bytes.NewReader(original))
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	stored = append(stored, key)
+	for thumbnailKey, thumbnail := range thumbnails {
+		err = app.storage.Put(thumbnailKey, bytes.NewReader(thumbnail))
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+		stored = append(stored, thumbnailKey)
+	}
+
+	previousKey := movie.PosterKey
+	movie.PosterKey = key
+	err = app.models.Movies.Update(r.Context(), movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	saved = true
+	if previousKey != "" {
+		app.background("delete poster", func(ctx context.Context) {
+			app.deletePoster(ctx, previousKey)
+		})
+	}
+
+	app.setPosterURLs(movie)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) deletePoster(ctx context.Context, key string) {
+	keys := []string{key}
+	for size := range posterSizes {
+		keys = append(keys, posterSizeKey(key, size))
+	}
+	for _, k := range keys {
+		if ctx.Err() != nil {
+			app.logger.PrintError(ctx.Err(), map[string]any{"key": k})
+			return
+		}
+		err := app.storage.Delete(k)
+		if err != nil {
+			app.logger.PrintError(err, map[string]any{"key": k})
+		}
+	}
+}
+
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(httprouter.ParamsFromContext(r.Context()).ByName("key"), "/")
+	f, err := app.storage.Open(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	// Keys embed a random suffix and are never overwritten.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, key, time.Time{}, f)
+}
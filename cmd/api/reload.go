@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"sulfur.test.net/internal/data/validator"
+)
+
+// reloadableFlags are the settings applied by a reload. Changes to anything
+// else are reported but need a restart.
+var reloadableFlags = map[string]bool{
+	"limiter-enabled":      true,
+	"limiter-rps":          true,
+	"limiter-burst":        true,
+	"limiter-apikey-rps":   true,
+	"limiter-apikey-burst": true,
+	"limiter-route":        true,
+	"limiter-tier":         true,
+	"cors-trusted-origins": true,
+	"log-level":            true,
+	"log-trace-level":      true,
+	"smtp-sender":          true,
+}
+
+// invalidConfigError is returned by reloadConfig when the new configuration
+// fails validation; the running configuration is left as it was.
+type invalidConfigError map[string]string
+
+func (e invalidConfigError) Error() string {
+	return "invalid configuration"
+}
+
+type configChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type reloadResult struct {
+	Changed         map[string]configChange `json:"changed"`
+	RestartRequired []string                `json:"restart_required"`
+}
+
+// reloadOnSIGHUP reloads the configuration each time the process gets SIGHUP.
+func (app *application) reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			app.reloadConfig()
+		}
+	}()
+}
+
+// reloadConfig reads the configuration again from the same file, environment
+// and command line as at startup and swaps in the reloadable settings.
+func (app *application) reloadConfig() (*reloadResult, error) {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	result, err := app.applyConfig()
+	if err != nil {
+		properties := map[string]any{}
+		var invalid invalidConfigError
+		if errors.As(err, &invalid) {
+			for key, message := range invalid {
+				properties[key] = message
+			}
+		}
+		app.logger.PrintError(errors.New("configuration reload rejected: "+err.Error()), properties)
+		return nil, err
+	}
+	app.logger.PrintInfo("configuration reloaded", map[string]any{
+		"changed":          result.Changed,
+		"restart_required": result.RestartRequired,
+	})
+	return result, nil
+}
+
+func (app *application) applyConfig() (*reloadResult, error) {
+	var next config
+	fs, _ := newConfigFlagSet(&next, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	_, err := loadConfig(fs, app.configArgs, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	v := validator.New()
+	if validateConfig(v, next); !v.Valid() {
+		return nil, invalidConfigError(v.Errors)
+	}
+
+	result := &reloadResult{Changed: map[string]configChange{}, RestartRequired: []string{}}
+	values := flagValues(fs)
+	for name, value := range values {
+		old := app.configValues[name]
+		if old == value {
+			continue
+		}
+		if !reloadableFlags[name] {
+			result.RestartRequired = append(result.RestartRequired, name)
+			continue
+		}
+		if secretFlags[name] {
+			old, value = redact(name, old), redact(name, value)
+		}
+		result.Changed[name] = configChange{Old: old, New: value}
+	}
+	sort.Strings(result.RestartRequired)
+
+	// Everything that can fail is done before anything is swapped.
+	err = app.mailer.SetSender(next.smtp.sender)
+	if err != nil {
+		return nil, err
+	}
+	live := *app.live.Load()
+	live.limiter.enabled = next.limiter.enabled
+	live.limiter.rps = next.limiter.rps
+	live.limiter.burst = next.limiter.burst
+	live.limiter.routes = next.limiter.routes
+	live.limiter.tiers = next.limiter.tiers
+	live.cors = next.cors
+	live.log.level = next.log.level
+	live.log.traceLevel = next.log.traceLevel
+	live.smtp.sender = next.smtp.sender
+	app.live.Store(&live)
+	app.logger.SetLevel(next.log.level)
+	app.logger.SetTraceLevel(next.log.traceLevel)
+
+	// Only reloadable settings were applied, so the others are reported
+	// again next time.
+	for name := range result.Changed {
+		app.configValues[name] = values[name]
+	}
+	return result, nil
+}
+
+// flagValues records the value of every setting in fs, for reloadConfig to
+// find what changed.
+func flagValues(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if !commandLineOnly[f.Name] {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	return values
+}
+
+func (app *application) reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		app.methodNotAllowed(w, r)
+		return
+	}
+	result, err := app.reloadConfig()
+	if err != nil {
+		var invalid invalidConfigError
+		switch {
+		case errors.As(err, &invalid):
+			app.failedValidationResponse(w, r, invalid)
+		default:
+			app.errorRespone(w, r, http.StatusUnprocessableEntity, err.Error())
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"reload": result}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
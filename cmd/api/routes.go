@@ -9,19 +9,85 @@ import (
 
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowed)
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMovieHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	router.NotFound = app.rateLimit("", http.HandlerFunc(app.notFoundResponse))
+	router.MethodNotAllowed = app.rateLimit("", http.HandlerFunc(app.methodNotAllowed))
+
+	// handle registers a route wrapped in the rate limiter, which needs the
+	// route pattern to pick a per-route policy, and records the pattern for
+	// the metrics middleware.
+	route := func(method, pattern string, handler http.HandlerFunc) http.Handler {
+		handler = app.traceStage("handler", handler).ServeHTTP
+		return app.recordRoute(pattern, app.traceStage("rateLimit", app.rateLimit(method+" "+pattern, handler)))
+	}
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.Handler(method, pattern, route(method, pattern, handler))
+	}
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodGet, "/v1/healthcheck/live", app.livenessHandler)
+	handle(http.MethodGet, "/v1/healthcheck/ready", app.readinessHandler)
+
+	handle(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMovieHandler))
+	handle(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	// httprouter can't register the static /v1/movies/suggest next to the
+	// /v1/movies/:id wildcard, so the wildcard route hands it on, still under
+	// its own pattern and rate limit policy.
+	showMovie := route(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	suggestMovies := route(http.MethodGet, "/v1/movies/suggest", app.requirePermission("movies:read", app.suggestMoviesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") == "suggest" {
+			suggestMovies.ServeHTTP(w, r)
+			return
+		}
+		showMovie.ServeHTTP(w, r)
+	})
+	handle(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	handle(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	handle(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.uploadPosterHandler))
+	handle(http.MethodGet, "/v1/posters/*key", app.showPosterHandler)
+	handle(http.MethodGet, "/v1/movies/:id/translations", app.requirePermission("movies:read", app.listTranslationsHandler))
+	handle(http.MethodPut, "/v1/movies/:id/translations/:language", app.requirePermission("movies:write", app.putTranslationHandler))
+	handle(http.MethodDelete, "/v1/movies/:id/translations/:language", app.requirePermission("movies:write", app.deleteTranslationHandler))
+	handle(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	handle(http.MethodPost, "/v1/genres", app.requirePermission("genres:write", app.createGenreHandler))
+	handle(http.MethodPost, "/v1/genres/:slug/merge", app.requirePermission("genres:write", app.mergeGenreHandler))
+
+	handle(http.MethodGet, "/v1/jobs", app.requirePermission("jobs:read", app.listJobsHandler))
+	handle(http.MethodGet, "/v1/jobs/:id", app.requirePermission("jobs:read", app.showJobHandler))
+	handle(http.MethodPost, "/v1/jobs/:id/retry", app.requirePermission("jobs:write", app.retryJobHandler))
+
+	// The mail provider authenticates with a signature rather than a token.
+	if app.config.mail.webhookSecret != "" {
+		handle(http.MethodPost, "/v1/mail/events", app.mailEventsHandler)
+	}
+
+	handle(http.MethodPost, "/v1/users", app.registerUserHandler)
+	handle(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	handle(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	// Without a separate debug listener the debug endpoints are served here
+	// but never to anonymous users.
+	if app.config.debug.addr == "" {
+		handle(http.MethodGet, "/debug/vars", app.requireDebugAccess("metrics:read", expvar.Handler().ServeHTTP))
+		handle(http.MethodGet, "/metrics", app.requireDebugAccess("metrics:read", app.instruments.registry.Handler))
+		handle(http.MethodGet, "/debug/mail", app.requireDebugAccess("metrics:read", app.mailPreviewHandler))
+		handle(http.MethodGet, "/debug/health/ready", app.requireDebugAccess("metrics:read", app.readinessDetailsHandler))
+		handle(http.MethodPost, "/debug/config/reload", app.requireDebugAccess("config:write", app.reloadConfigHandler))
+	}
+
+	// Middleware from the innermost out; each runs in its own trace span.
+	var handler http.Handler = router
+	for _, stage := range []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+	}{
+		{"authenticate", app.authenticate},
+		{"enableCORS", app.enableCORS},
+		{"recoverPanic", app.recoverPanic},
+		{"metrics", app.metrics},
+		{"logRequest", app.logRequest},
+		{"strictTransportSecurity", app.strictTransportSecurity},
+	} {
+		handler = app.traceStage(stage.name, stage.middleware(handler))
+	}
+	return app.resolveClientIP(app.trackRequest(app.traceRequest(handler)))
 }
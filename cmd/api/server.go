@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -21,33 +23,157 @@ func (app *application) serve() error {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	var debugSrv *http.Server
+	if app.config.debug.addr != "" {
+		debugSrv = &http.Server{
+			Addr:        app.config.debug.addr,
+			Handler:     app.debugRoutes(),
+			ErrorLog:    log.New(app.logger, "", 0),
+			IdleTimeout: time.Minute,
+			ReadTimeout: 10 * time.Second,
+			// CPU profiles and execution traces take 30 seconds by default.
+			WriteTimeout: 2 * time.Minute,
+		}
+	}
+
+	var certs *certReloader
+	if app.config.tls.certFile != "" {
+		var err error
+		certs, err = newCertReloader(app.config.tls.certFile, app.config.tls.keyFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = app.tlsConfig(certs)
+	}
+	var redirectSrv *http.Server
+	if app.config.tls.redirectAddr != "" {
+		redirectSrv = &http.Server{
+			Addr:         app.config.tls.redirectAddr,
+			Handler:      http.HandlerFunc(app.redirectToHTTPS),
+			ErrorLog:     log.New(app.logger, "", 0),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+	}
+
+	// Bind the debug listener before anything starts, so that an address that
+	// can't be used stops startup instead of leaving the server without it.
+	var debugLn net.Listener
+	if debugSrv != nil {
+		var err error
+		debugLn, err = net.Listen("tcp", debugSrv.Addr)
+		if err != nil {
+			return fmt.Errorf("debug server: %w", err)
+		}
+	}
+
+	app.reloadOnSIGHUP()
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	if certs != nil && app.config.tls.reloadInterval > 0 {
+		go certs.watch(watchCtx, app.config.tls.reloadInterval, app.logger)
+	}
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	app.startJobWorkers(jobsCtx)
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	app.startTokenCleanup(cleanupCtx, app.models.Tokens)
+
 	shutdownError := make(chan error)
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		s := <-quit
 
-		app.logger.PrintInfo("shutting down server", map[string]string{
-			"signal": s.String(),
+		app.logger.PrintInfo("shutting down server", map[string]any{
+			"signal":  s.String(),
+			"timeout": app.config.shutdown.timeout,
 		})
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		// Every step below shares one deadline, so a hung task can't keep the
+		// process alive until the orchestrator kills it.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdown.timeout)
 		defer cancel()
-		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- err
+
+		// Fail readiness checks first, and give load balancers time to notice
+		// before the listeners close.
+		app.shuttingDown.Store(true)
+		if app.config.health.drainDelay > 0 {
+			app.logger.PrintInfo("draining", map[string]any{"delay": app.config.health.drainDelay})
+			time.Sleep(app.config.health.drainDelay)
+		}
+
+		// The servers shut down together, so a slow request on one doesn't
+		// use up the others' share of the timeout.
+		httpCtx, cancelHTTP := context.WithTimeout(ctx, app.config.shutdown.httpTimeout)
+		servers := []*http.Server{srv, redirectSrv, debugSrv}
+		errs := make([]error, len(servers))
+		var wg sync.WaitGroup
+		for i, server := range servers {
+			if server == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(i int, server *http.Server) {
+				defer wg.Done()
+				err := server.Shutdown(httpCtx)
+				if err != nil {
+					// Drop the connections still serving requests.
+					server.Close()
+					errs[i] = fmt.Errorf("shutting down %s: %w", server.Addr, err)
+				}
+			}(i, server)
 		}
-		// complete their tasks.
-		app.logger.PrintInfo("completing background tasks", map[string]string{
+		wg.Wait()
+		cancelHTTP()
+
+		app.logger.PrintInfo("completing background tasks", map[string]any{
 			"addr": srv.Addr,
 		})
-		app.wg.Wait()
-		shutdownError <- nil
+		// Workers finish the job they're running but don't claim new ones.
+		stopJobs()
+		stopCleanup()
+		errs = append(errs, app.stopTasks(ctx))
+		// Flush spans from the background tasks too.
+		errs = append(errs, app.tracer.Shutdown(ctx))
+		shutdownError <- errors.Join(errs...)
 	}()
-	app.logger.PrintInfo("starting server", map[string]string{
+	if debugSrv != nil {
+		go func() {
+			app.logger.PrintInfo("starting debug server", map[string]any{
+				"addr":  debugSrv.Addr,
+				"pprof": app.config.debug.pprof,
+			})
+			err := debugSrv.Serve(debugLn)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]any{"addr": debugSrv.Addr})
+			}
+		}()
+	}
+	if redirectSrv != nil {
+		go func() {
+			app.logger.PrintInfo("starting HTTPS redirect server", map[string]any{
+				"addr": redirectSrv.Addr,
+			})
+			err := redirectSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]any{"addr": redirectSrv.Addr})
+			}
+		}()
+	}
+	app.logger.PrintInfo("starting server", map[string]any{
 		"addr": srv.Addr,
 		"env":  app.config.env,
+		"tls":  srv.TLSConfig != nil,
 	})
-	err := srv.ListenAndServe()
+	var err error
+	if srv.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate. Serving TLS
+		// also enables HTTP/2.
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -55,8 +181,34 @@ func (app *application) serve() error {
 	if err != nil {
 		return err
 	}
-	app.logger.PrintInfo("stopped server", map[string]string{
+	app.logger.PrintInfo("stopped server", map[string]any{
 		"addr": srv.Addr,
 	})
 	return nil
 }
+
+// stopTasks gives background tasks -shutdown-task-timeout to finish, then
+// cancels the root context and waits for them until ctx is done. Tasks still
+// running after that are logged and abandoned.
+func (app *application) stopTasks(ctx context.Context) error {
+	taskCtx, cancel := context.WithTimeout(ctx, app.config.shutdown.taskTimeout)
+	defer cancel()
+	if app.tasks.wait(taskCtx) == nil {
+		return nil
+	}
+	app.logger.PrintInfo("cancelling background tasks", map[string]any{
+		"running": len(app.tasks.list()),
+	})
+	app.tasks.stop()
+	if app.tasks.wait(ctx) == nil {
+		return nil
+	}
+	running := app.tasks.list()
+	for _, task := range running {
+		app.logger.PrintError(errors.New("background task did not stop"), map[string]any{
+			"task":    task.Name,
+			"running": time.Since(task.Started).Round(time.Millisecond).String(),
+		})
+	}
+	return fmt.Errorf("shutdown timed out with %d background tasks running", len(running))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// taskGroup tracks the goroutines that outlive a request, so shutdown can
+// wait for them, cancel them, and report the ones that never finished. Its
+// context is the application's root context: tasks should pass it on to
+// anything that may block. The zero value is ready to use.
+type taskGroup struct {
+	once    sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	nextID  int
+	running map[int]runningTask
+}
+
+type runningTask struct {
+	Name    string
+	Started time.Time
+}
+
+func (g *taskGroup) init() {
+	g.once.Do(func() {
+		g.ctx, g.cancel = context.WithCancel(context.Background())
+		g.running = make(map[int]runningTask)
+	})
+}
+
+// context returns the root context, which is cancelled by stop.
+func (g *taskGroup) context() context.Context {
+	g.init()
+	return g.ctx
+}
+
+// start registers a task named name; the task calls done when it returns.
+func (g *taskGroup) start(name string) (done func()) {
+	g.init()
+	g.wg.Add(1)
+	g.mu.Lock()
+	id := g.nextID
+	g.nextID++
+	g.running[id] = runningTask{Name: name, Started: time.Now()}
+	g.mu.Unlock()
+	return func() {
+		g.mu.Lock()
+		delete(g.running, id)
+		g.mu.Unlock()
+		g.wg.Done()
+	}
+}
+
+// stop cancels the root context.
+func (g *taskGroup) stop() {
+	g.init()
+	g.cancel()
+}
+
+// wait waits for every task to finish, or until ctx is done.
+func (g *taskGroup) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// list returns the tasks still running, oldest first.
+func (g *taskGroup) list() []runningTask {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	tasks := make([]runningTask, 0, len(g.running))
+	for _, task := range g.running {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Started.Before(tasks[j].Started)
+	})
+	return tasks
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sulfur.test.net/internal/data/jsonlog"
+)
+
+func TestStopTasks(t *testing.T) {
+	var logs bytes.Buffer
+	app := &application{logger: jsonlog.New(&logs, jsonlog.LevelInfo)}
+	app.config.shutdown.taskTimeout = 10 * time.Millisecond
+
+	// A task that stops when the root context is cancelled.
+	app.background("wait for cancel", func(ctx context.Context) {
+		<-ctx.Done()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, app.stopTasks(ctx))
+	assert.Empty(t, app.tasks.list())
+
+	// One that doesn't is reported once the deadline passes.
+	release := make(chan struct{})
+	defer close(release)
+	app = &application{logger: jsonlog.New(&logs, jsonlog.LevelInfo)}
+	app.config.shutdown.taskTimeout = 10 * time.Millisecond
+	app.background("hung send", func(context.Context) {
+		<-release
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.EqualError(t, app.stopTasks(ctx), "shutdown timed out with 1 background tasks running")
+	assert.Contains(t, logs.String(), `"task":"hung send"`)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"sulfur.test.net/internal/data/jsonlog"
+	"sulfur.test.net/internal/data/validator"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuiteIDs has the suites crypto/tls considers secure; the others can't
+// be configured.
+var cipherSuiteIDs = func() map[string]uint16 {
+	ids := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+	return ids
+}()
+
+func validateCipherSuites(v *validator.Validator, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	http2Capable := false
+	for _, name := range names {
+		if _, found := cipherSuiteIDs[name]; !found {
+			v.AddError("tls-cipher-suites", fmt.Sprintf("%s is not a supported cipher suite", name))
+			return
+		}
+		// HTTP/2 refuses to start without one of these (RFC 7540 section 9.2.2).
+		if name == "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" || name == "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256" {
+			http2Capable = true
+		}
+	}
+	v.Check(http2Capable, "tls-cipher-suites", "must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 for HTTP/2")
+}
+
+// tlsConfig builds the server's TLS configuration. The certificate comes from
+// a certReloader so it can be replaced without a restart.
+func (app *application) tlsConfig(certs *certReloader) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tlsVersions[app.config.tls.minVersion],
+		GetCertificate: certs.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	for _, name := range app.config.tls.cipherSuites {
+		cfg.CipherSuites = append(cfg.CipherSuites, cipherSuiteIDs[name])
+	}
+	return cfg
+}
+
+// certReloader serves a certificate loaded from disk and, while watching,
+// loads it again when the files change, e.g. after a renewal.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	modTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	_, err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// reload loads the certificate if either file has changed since the last
+// load, reporting whether it did.
+func (c *certReloader) reload() (bool, error) {
+	var modTime time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if !modTime.After(c.modTime) {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, err
+	}
+	c.cert.Store(&cert)
+	c.modTime = modTime
+	return true, nil
+}
+
+// watch checks the files every interval until ctx is done. A certificate
+// that fails to load is logged and the previous one kept.
+func (c *certReloader) watch(ctx context.Context, interval time.Duration, logger *jsonlog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := c.reload()
+			if err != nil {
+				logger.PrintError(fmt.Errorf("reloading TLS certificate: %w", err), map[string]any{"cert_file": c.certFile})
+				continue
+			}
+			if reloaded {
+				logger.PrintInfo("TLS certificate reloaded", map[string]any{
+					"cert_file": c.certFile,
+					"not_after": c.cert.Load().Leaf.NotAfter,
+				})
+			}
+		}
+	}
+}
+
+// redirectToHTTPS is served by the -tls-redirect-addr listener.
+func (app *application) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.Trim(r.Host, "[]")
+	}
+	if app.config.port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// strictTransportSecurity tells browsers to use HTTPS for future requests.
+// Browsers ignore the header on plain HTTP, so it is safe to send behind a
+// proxy that terminates TLS.
+func (app *application) strictTransportSecurity(next http.Handler) http.Handler {
+	if app.config.tls.hstsMaxAge <= 0 {
+		return next
+	}
+	value := "max-age=" + strconv.Itoa(int(app.config.tls.hstsMaxAge.Seconds()))
+	if app.config.tls.hstsIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sulfur.test.net/internal/data/validator"
+)
+
+// writeTestCert writes a self-signed certificate for localhost with the
+// given serial number and returns it.
+func writeTestCert(t *testing.T, certFile, keyFile string, serial int64) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := writeTestCert(t, certFile, keyFile, 1)
+
+	certs, err := newCertReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	app := &application{}
+	app.config.tls.minVersion = "1.2"
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	srv.TLS = app.tlsConfig(certs)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(first)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "localhost"},
+		ForceAttemptHTTP2: true,
+	}}
+	res, err := client.Get(srv.URL)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 2, res.ProtoMajor)
+
+	reloaded, err := certs.reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded, "files unchanged")
+
+	second := writeTestCert(t, certFile, keyFile, 2)
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+	reloaded, err = certs.reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, second.SerialNumber, certs.cert.Load().Leaf.SerialNumber)
+
+	// A broken file keeps the certificate that was loaded.
+	assert.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	later = later.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(keyFile, later, later))
+	_, err = certs.reload()
+	assert.Error(t, err)
+	assert.Equal(t, second.SerialNumber, certs.cert.Load().Leaf.SerialNumber)
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		port   int
+		target string
+		want   string
+	}{
+		{443, "http://example.com/v1/movies?page=2", "https://example.com/v1/movies?page=2"},
+		{443, "http://example.com:80/", "https://example.com/"},
+		{4000, "http://example.com/", "https://example.com:4000/"},
+		{443, "http://[::1]:80/", "https://[::1]/"},
+	}
+	for _, tt := range tests {
+		app := &application{}
+		app.config.port = tt.port
+		w := httptest.NewRecorder()
+		app.redirectToHTTPS(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+		assert.Equal(t, tt.want, w.Header().Get("Location"))
+	}
+}
+
+func TestValidateCipherSuites(t *testing.T) {
+	v := validator.New()
+	validateCipherSuites(v, []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"})
+	assert.True(t, v.Valid())
+
+	v = validator.New()
+	validateCipherSuites(v, []string{"TLS_RSA_WITH_RC4_128_SHA"})
+	assert.False(t, v.Valid(), "insecure suite")
+
+	v = validator.New()
+	validateCipherSuites(v, []string{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"})
+	assert.False(t, v.Valid(), "no HTTP/2 suite")
+}
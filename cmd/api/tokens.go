@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"time"
@@ -29,16 +30,17 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	app.logger.PrintInfo(input.Email, nil)
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecordFound):
+			app.logFailedLogin(r, input.Email, "unknown email")
 			app.invalidCredentialResponse(w, r)
 		default:
 			app.serverErrorRespone(w, r, err)
 		}
+		return
 	}
 
 	match, err := user.Password.Matches(input.Password)
@@ -47,18 +49,80 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 	if !match {
+		app.logFailedLogin(r, input.Email, "password mismatch")
 		app.invalidCredentialResponse(w, r)
 		return
 	}
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
 	}
 
 }
+
+func (app *application) logFailedLogin(r *http.Request, email, reason string) {
+	app.logger.PrintInfo("failed authentication attempt", map[string]any{
+		"email":     email,
+		"reason":    reason,
+		"client_ip": app.contextGetClientIP(r),
+	})
+}
+
+// expiredTokenDeleter deletes up to limit expired tokens, like
+// data.TokenModel.DeleteExpired.
+type expiredTokenDeleter interface {
+	DeleteExpired(ctx context.Context, limit int) (int64, error)
+}
+
+// startTokenCleanup deletes expired tokens every -tokens-cleanup-interval
+// until ctx is cancelled. It runs as a background task, so shutdown waits for
+// the batch in progress.
+func (app *application) startTokenCleanup(ctx context.Context, tokens expiredTokenDeleter) {
+	interval := app.config.tokens.cleanupInterval
+	if interval <= 0 {
+		return
+	}
+	app.background("token cleanup", func(context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			start := time.Now()
+			deleted, err := purgeExpiredTokens(ctx, tokens, app.config.tokens.cleanupBatchSize)
+			app.instruments.tokensPurged.Add(float64(deleted))
+			if err != nil {
+				app.logger.PrintError(err, map[string]any{"component": "tokens", "deleted": deleted})
+			} else if deleted > 0 {
+				app.logger.PrintInfo("expired tokens deleted", map[string]any{
+					"deleted":     deleted,
+					"duration_ms": time.Since(start).Milliseconds(),
+				})
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// purgeExpiredTokens deletes expired tokens in batches of batchSize until
+// none are left or ctx is cancelled. A batch already started runs to
+// completion.
+func purgeExpiredTokens(ctx context.Context, tokens expiredTokenDeleter, batchSize int) (int64, error) {
+	var total int64
+	for ctx.Err() == nil {
+		deleted, err := tokens.DeleteExpired(context.WithoutCancel(ctx), batchSize)
+		total += deleted
+		if err != nil || deleted < int64(batchSize) {
+			return total, err
+		}
+	}
+	return total, nil
+}
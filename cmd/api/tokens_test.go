@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sulfur.test.net/internal/data/jsonlog"
+)
+
+// fakeTokens has expired tokens to delete, and records the batches asked for.
+type fakeTokens struct {
+	mu      sync.Mutex
+	expired int64
+	batches []int
+	err     error
+	// onDelete, if set, is called after each batch.
+	onDelete func()
+}
+
+func (f *fakeTokens) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+	f.mu.Lock()
+	f.batches = append(f.batches, limit)
+	deleted := min(f.expired, int64(limit))
+	f.expired -= deleted
+	err := f.err
+	onDelete := f.onDelete
+	f.mu.Unlock()
+	if onDelete != nil {
+		onDelete()
+	}
+	return deleted, err
+}
+
+func (f *fakeTokens) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.batches)
+}
+
+func TestPurgeExpiredTokens(t *testing.T) {
+	// Full batches continue until a short one.
+	tokens := &fakeTokens{expired: 25}
+	deleted, err := purgeExpiredTokens(context.Background(), tokens, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(25), deleted)
+	assert.Equal(t, []int{10, 10, 10}, tokens.batches)
+
+	tokens = &fakeTokens{expired: 20}
+	deleted, err = purgeExpiredTokens(context.Background(), tokens, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), deleted)
+	assert.Len(t, tokens.batches, 3, "an empty batch ends the purge")
+
+	tokens = &fakeTokens{expired: 100, err: errors.New("connection reset")}
+	deleted, err = purgeExpiredTokens(context.Background(), tokens, 10)
+	assert.Error(t, err)
+	assert.Equal(t, int64(10), deleted)
+	assert.Len(t, tokens.batches, 1)
+
+	// Cancellation stops the purge between batches, but the batch in
+	// progress finishes.
+	ctx, cancel := context.WithCancel(context.Background())
+	tokens = &fakeTokens{expired: 100, onDelete: cancel}
+	deleted, err = purgeExpiredTokens(ctx, tokens, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), deleted)
+	assert.Len(t, tokens.batches, 1)
+}
+
+func TestStartTokenCleanup(t *testing.T) {
+	var logs bytes.Buffer
+	app := &application{
+		logger:      jsonlog.New(&logs, jsonlog.LevelInfo),
+		instruments: newInstruments(nil),
+	}
+	app.config.tokens.cleanupInterval = time.Millisecond
+	app.config.tokens.cleanupBatchSize = 10
+
+	tokens := &fakeTokens{expired: 15}
+	ctx, cancel := context.WithCancel(context.Background())
+	app.startTokenCleanup(ctx, tokens)
+	assert.Eventually(t, func() bool { return tokens.calls() >= 4 }, time.Second, time.Millisecond,
+		"the worker purges on every tick")
+
+	cancel()
+	stopCtx, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	assert.NoError(t, app.tasks.wait(stopCtx), "the worker stops when its context is cancelled")
+	assert.Empty(t, app.tasks.list())
+	assert.Contains(t, logs.String(), `"deleted":15`)
+
+	// A zero interval disables the worker.
+	app = &application{}
+	app.startTokenCleanup(context.Background(), tokens)
+	assert.Empty(t, app.tasks.list())
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"sulfur.test.net/internal/data"
+	"sulfur.test.net/internal/data/validator"
+)
+
+// localize replaces the title and synopsis of each movie with the best
+// translation for the request's Accept-Language header. A movie keeps its
+// original text when the default language is preferred over every available
+// translation.
+func (app *application) localize(w http.ResponseWriter, r *http.Request, movies ...*data.Movie) error {
+	w.Header().Add("Vary", "Accept-Language")
+	for _, movie := range movies {
+		movie.Language = app.config.defaultLanguage
+	}
+
+	languages := app.readAcceptLanguage(r)
+	var wanted []string
+	for _, language := range languages {
+		if language == app.config.defaultLanguage {
+			break
+		}
+		wanted = append(wanted, language)
+	}
+	if len(wanted) == 0 || len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(movies))
+	for _, movie := range movies {
+		ids = append(ids, movie.ID)
+	}
+	translations, err := app.models.Translations.GetForMovies(r.Context(), ids, wanted)
+	if err != nil {
+		return err
+	}
+	byMovie := make(map[int64]map[string]*data.Translation)
+	for _, t := range translations {
+		if byMovie[t.MovieID] == nil {
+			byMovie[t.MovieID] = make(map[string]*data.Translation)
+		}
+		byMovie[t.MovieID][t.Language] = t
+	}
+	for _, movie := range movies {
+		for _, language := range wanted {
+			if t, found := byMovie[movie.ID][language]; found {
+				movie.Title = t.Title
+				if t.Synopsis != "" {
+					movie.Synopsis = t.Synopsis
+				}
+				movie.Language = t.Language
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (app *application) readLanguageParam(r *http.Request) string {
+	return strings.ToLower(httprouter.ParamsFromContext(r.Context()).ByName("language"))
+}
+
+func (app *application) listTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	translations, err := app.models.Translations.GetAllForMovie(r.Context(), id)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"translations": translations}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) putTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	var input struct {
+		Title    string `json:"title"`
+		Synopsis string `json:"synopsis"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	translation := &data.Translation{
+		MovieID:  id,
+		Language: app.readLanguageParam(r),
+		Title:    input.Title,
+		Synopsis: input.Synopsis,
+	}
+	v := validator.New()
+	if data.ValidateTranslation(v, translation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Translations.Upsert(r.Context(), translation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"translation": translation}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) deleteTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Translations.Delete(r.Context(), id, app.readLanguageParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecordFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "translation succesfuly deleted"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
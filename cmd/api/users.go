@@ -14,6 +14,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Language string `json:"language"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -24,6 +25,18 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name:      input.Name,
 		Email:     input.Email,
 		Activated: false,
+		Language:  input.Language,
+	}
+	// Without an explicit choice, emails go out in the best supported
+	// language the browser asked for.
+	locales := app.mailer.Locales()
+	if user.Language == "" {
+		for _, language := range app.readAcceptLanguage(r) {
+			if validator.PermittedValue(language, locales...) {
+				user.Language = language
+				break
+			}
+		}
 	}
 	err = user.Password.Set(input.Password)
 	if err != nil {
@@ -31,12 +44,21 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	v := validator.New()
-	if data.ValidateUser(v, user); !v.Valid() {
+	data.ValidateUser(v, user)
+	if data.ValidateLanguage(v, user.Language, locales); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.models.Users.Insert(user)
-
+	// The user only exists along with their permissions, activation token
+	// and welcome email, or they could never activate and couldn't register
+	// again with the same address.
+	tx, err := app.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+	err = app.models.Users.InsertTx(r.Context(), tx, user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -47,28 +69,33 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	err = app.models.Permissions.AddForUserTx(r.Context(), tx, user.ID, "movies:read")
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
 	}
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.models.Tokens.NewTx(r.Context(), tx, user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
 	}
-	app.background(func() {
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-		}
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
-	})
+	data := map[string]any{
+		"activationToken": token.Plaintext,
+		"userID":          user.ID,
+	}
+	err = app.enqueueEmailTx(r.Context(), tx, user.Email, "user_welcome.tmpl", user.Language, data)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+	app.wakeJobWorker()
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
@@ -88,7 +115,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecordFound):
@@ -101,7 +128,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 	user.Activated = true
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -111,12 +138,12 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
@@ -0,0 +1,97 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver works out the address of the client that made a request. The
+// X-Forwarded-For and X-Real-IP headers are only believed when the request
+// arrived from one of the trusted proxies; anyone else could put any address
+// in them.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// New accepts CIDR ranges ("10.0.0.0/8") and single addresses ("10.1.2.3").
+func New(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHop parses an address as found in RemoteAddr or a forwarding header,
+// with or without a port.
+func parseHop(value string) net.IP {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	return net.ParseIP(strings.Trim(value, "[]"))
+}
+
+// ClientIP returns the rightmost address in the forwarding chain that isn't
+// one of our trusted proxies. Walking from the right means a client can't
+// choose its address by prepending entries to X-Forwarded-For.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	remote := parseHop(req.RemoteAddr)
+	if remote == nil {
+		return req.RemoteAddr
+	}
+	if !r.isTrusted(remote) {
+		return remote.String()
+	}
+
+	var hops []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip := parseHop(req.Header.Get("X-Real-IP")); ip != nil {
+			return ip.String()
+		}
+		return remote.String()
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
@@ -0,0 +1,48 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := New([]string{"10.0.0.0/8", "192.0.2.1"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{"untrusted peer ignores headers", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted peer without headers", "10.0.0.1:5000", "", "", "10.0.0.1"},
+		{"trusted peer with real ip", "10.0.0.1:5000", "", "198.51.100.2", "198.51.100.2"},
+		{"rightmost untrusted hop", "10.0.0.1:5000", "6.6.6.6, 198.51.100.1, 10.0.0.2", "", "198.51.100.1"},
+		{"single address proxy", "192.0.2.1:443", "198.51.100.1", "", "198.51.100.1"},
+		{"all hops trusted", "10.0.0.1:5000", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"garbage hop stops the walk", "10.0.0.1:5000", "198.51.100.1, nonsense", "", "10.0.0.1"},
+		{"ipv6 peer", "[2001:db8::1]:5000", "198.51.100.1", "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.want, resolver.ClientIP(req))
+		})
+	}
+}
+
+func TestNew_InvalidProxy(t *testing.T) {
+	_, err := New([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"sulfur.test.net/internal/data/validator"
+)
+
+var FacetSafeList = []string{"genres", "year", "runtime"}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// facetQueries select (facet, value, count, position) for every facet, where
+// position orders the values within a facet: genres by descending count,
+// decades and runtime buckets chronologically / by length.
+var facetQueries = map[string]string{
+	"genres": `
+	SELECT 'genres', value, count(*), row_number() OVER (ORDER BY count(*) DESC, value)
+	FROM movies, unnest(movies.genres) AS facet(value)
+	WHERE %s
+	GROUP BY value`,
+	"year": `
+	SELECT 'year', (year / 10 * 10)::text || 's', count(*), year / 10 * 10
+	FROM movies
+	WHERE %s
+	GROUP BY year / 10 * 10`,
+	"runtime": `
+	SELECT 'runtime', bucket, count(*), min(runtime)
+	FROM (
+		SELECT runtime, CASE
+			WHEN runtime < 90 THEN '<90'
+			WHEN runtime < 120 THEN '90-119'
+			WHEN runtime < 150 THEN '120-149'
+			ELSE '150+'
+		END AS bucket
+		FROM movies
+		WHERE %s
+	) AS buckets
+	GROUP BY bucket`,
+}
+
+func ValidateFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(validator.PermittedValue(facet, FacetSafeList...), "facets", "invalid facet value")
+	}
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+// GetFacets counts the movies matching the same filters as GetAll per value
+// of each requested facet, in a single round trip.
+func (m MovieModel) GetFacets(ctx context.Context, title string, genres []string, fuzzy bool, facets []string) (map[string][]*FacetCount, error) {
+	result := make(map[string][]*FacetCount)
+	if len(facets) == 0 {
+		return result, nil
+	}
+	parts := make([]string, 0, len(facets))
+	for _, facet := range facets {
+		query, ok := facetQueries[facet]
+		if !ok {
+			panic("unsafe facet parameter " + facet)
+		}
+		parts = append(parts, fmt.Sprintf(query, movieFilterCondition(fuzzy)))
+		result[facet] = []*FacetCount{}
+	}
+	query := strings.Join(parts, "\n\tUNION ALL") + "\n\tORDER BY 1, 4"
+
+	ctx, span := startQuery(ctx, "MovieModel.GetFacets")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var facet string
+		var position int64
+		var count FacetCount
+		err := rows.Scan(&facet, &count.Value, &count.Count, &position)
+		if err != nil {
+			return nil, err
+		}
+		result[facet] = append(result[facet], &count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"sulfur.test.net/internal/data/validator"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	// ErrDuplicateGenreName is returned when a slug or alias already names
+	// another genre.
+	ErrDuplicateGenreName = errors.New("duplicate genre name")
+
+	slugSeparatorRX = regexp.MustCompile(`[\s_]+`)
+	SlugRX          = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+)
+
+type Genre struct {
+	ID         int64    `json:"id"`
+	Slug       string   `json:"slug"`
+	Name       string   `json:"name"`
+	Aliases    []string `json:"aliases"`
+	MovieCount int      `json:"movie_count"`
+	Version    int32    `json:"version"`
+}
+
+// Slugify turns a free-text genre such as "Science Fiction" into the form
+// stored in the catalogue and in movies.genres ("science-fiction").
+func Slugify(value string) string {
+	return slugSeparatorRX.ReplaceAllString(strings.ToLower(strings.TrimSpace(value)), "-")
+}
+
+type Genres []*Genre
+
+// Canonical returns the slug of the catalogue genre matching value either by
+// slug or by one of its aliases.
+func (g Genres) Canonical(value string) (string, bool) {
+	slug := Slugify(value)
+	for i := range g {
+		if g[i].Slug == slug {
+			return g[i].Slug, true
+		}
+	}
+	for i := range g {
+		for _, alias := range g[i].Aliases {
+			if alias == slug {
+				return g[i].Slug, true
+			}
+		}
+	}
+	return "", false
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(len(genre.Slug) <= 100, "slug", "must not to be more than 100 bytes long")
+	v.Check(validator.Matches(genre.Slug, SlugRX), "slug", "must contain only lowercase letters, digits and dashes")
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not to be more than 100 bytes long")
+	for _, alias := range genre.Aliases {
+		v.Check(validator.Matches(alias, SlugRX), "aliases", "must contain only lowercase letters, digits and dashes")
+		v.Check(alias != genre.Slug, "aliases", "must not contain the slug itself")
+	}
+	v.Check(validator.Unique(genre.Aliases), "aliases", "must not contain duplicate values")
+}
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+func (m GenreModel) Insert(ctx context.Context, genre *Genre) error {
+	query := `
+	INSERT INTO genres (slug, name, aliases)
+	VALUES ($1, $2, $3)
+	RETURNING id, version`
+	args := []any{genre.Slug, genre.Name, pq.Array(genre.Aliases)}
+	ctx, span := startQuery(ctx, "GenreModel.Insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&genre.ID, &genre.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "genres_slug_key"`:
+			return ErrDuplicateGenre
+		case err.Error() == `pq: duplicate key value violates unique constraint "genre_names_pkey"`:
+			return ErrDuplicateGenreName
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAll returns the catalogue without movie counts; it is what handlers use
+// to canonicalise genres on input.
+func (m GenreModel) GetAll(ctx context.Context) (Genres, error) {
+	query := `
+	SELECT id, slug, name, aliases, version
+	FROM genres
+	ORDER BY slug`
+	ctx, span := startQuery(ctx, "GenreModel.GetAll")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	genres := Genres{}
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(&genre.ID, &genre.Slug, &genre.Name, pq.Array(&genre.Aliases), &genre.Version)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+func (m GenreModel) GetAllWithMovieCounts(ctx context.Context) (Genres, error) {
+	query := `
+	SELECT genres.id, genres.slug, genres.name, genres.aliases, genres.version, count(movies.id)
+	FROM genres
+	LEFT JOIN movies ON genres.slug = ANY(movies.genres)
+	GROUP BY genres.id
+	ORDER BY genres.slug`
+	ctx, span := startQuery(ctx, "GenreModel.GetAllWithMovieCounts")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	genres := Genres{}
+	for rows.Next() {
+		var genre Genre
+		err := rows.Scan(
+			&genre.ID,
+			&genre.Slug,
+			&genre.Name,
+			pq.Array(&genre.Aliases),
+			&genre.Version,
+			&genre.MovieCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return genres, nil
+}
+
+// Merge folds the source genre into target: every movie tagged with source is
+// retagged with target, source and its aliases become aliases of target, and
+// source is removed from the catalogue. It returns the number of movies
+// rewritten. Source is deleted before target takes over its names, so they
+// don't collide in genre_names.
+func (m GenreModel) Merge(ctx context.Context, source, target string) (int64, error) {
+	ctx, span := startQuery(ctx, "GenreModel.Merge")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var sourceAliases []string
+	query := `
+	DELETE FROM genres
+	WHERE slug = $1
+	RETURNING aliases`
+	err = tx.QueryRowContext(ctx, query, source).Scan(pq.Array(&sourceAliases))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNoRecordFound
+		default:
+			return 0, err
+		}
+	}
+
+	query = `
+	UPDATE genres
+	SET aliases = ARRAY(
+		SELECT DISTINCT alias
+		FROM unnest(aliases || $3::text[] || $1::text) AS alias
+		WHERE alias <> slug
+		ORDER BY alias
+	), version = version + 1
+	WHERE slug = $2`
+	result, err := tx.ExecContext(ctx, query, source, target, pq.Array(sourceAliases))
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected == 0 {
+		return 0, ErrNoRecordFound
+	}
+
+	query = `
+	UPDATE movies
+	SET genres = CASE WHEN $2 = ANY(genres) THEN array_remove(genres, $1) ELSE array_replace(genres, $1, $2) END,
+	version = version + 1
+	WHERE $1 = ANY(genres)`
+	result, err = tx.ExecContext(ctx, query, source, target)
+	if err != nil {
+		return 0, err
+	}
+	moviesRewritten, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return moviesRewritten, nil
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"Drama", "drama"},
+		{"  Science Fiction ", "science-fiction"},
+		{"science_fiction", "science-fiction"},
+		{"Film\tNoir", "film-noir"},
+		{"sci  _ fi", "sci-fi"},
+		{"already-a-slug", "already-a-slug"},
+		{"   ", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Slugify(tt.value), "%q", tt.value)
+	}
+}
+
+func TestGenres_Canonical(t *testing.T) {
+	genres := Genres{
+		{Slug: "science-fiction", Aliases: []string{"sci-fi", "scifi"}},
+		{Slug: "drama"},
+		// A slug wins over another genre's alias.
+		{Slug: "romance", Aliases: []string{"drama"}},
+	}
+	tests := []struct {
+		value string
+		want  string
+		found bool
+	}{
+		{"science-fiction", "science-fiction", true},
+		{"Science Fiction", "science-fiction", true},
+		{"sci-fi", "science-fiction", true},
+		{"SciFi", "science-fiction", true},
+		{"drama", "drama", true},
+		{"thriller", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, found := genres.Canonical(tt.value)
+		assert.Equal(t, tt.found, found, "%q", tt.value)
+		assert.Equal(t, tt.want, got, "%q", tt.value)
+	}
+}
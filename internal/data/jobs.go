@@ -0,0 +1,303 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"sulfur.test.net/internal/data/validator"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+var JobStatuses = []string{JobPending, JobRunning, JobSucceeded, JobDead}
+
+// Job is a unit of background work, e.g. sending an email, that survives
+// restarts. The payload isn't exposed over the API since it can hold secrets
+// such as activation tokens.
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"-"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func ValidateJobStatus(v *validator.Validator, status string) {
+	v.Check(status == "" || validator.PermittedValue(status, JobStatuses...), "status", "invalid status value")
+}
+
+type JobModel struct {
+	DB *sql.DB
+}
+
+// Enqueue stores a job of kind to run as soon as a worker is free. payload is
+// marshalled to JSON.
+func (m JobModel) Enqueue(ctx context.Context, kind string, payload any, maxAttempts int) (*Job, error) {
+	return m.enqueue(ctx, m.DB, kind, payload, maxAttempts)
+}
+
+// EnqueueTx is Enqueue as part of tx, so the job only exists if tx commits.
+func (m JobModel) EnqueueTx(ctx context.Context, tx *sql.Tx, kind string, payload any, maxAttempts int) (*Job, error) {
+	return m.enqueue(ctx, tx, kind, payload, maxAttempts)
+}
+
+func (m JobModel) enqueue(ctx context.Context, q queryer, kind string, payload any, maxAttempts int) (*Job, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	INSERT INTO jobs (kind, payload, max_attempts)
+	VALUES ($1, $2, $3)
+	RETURNING id, status, run_at, created_at, updated_at`
+	job := &Job{Kind: kind, Payload: js, MaxAttempts: maxAttempts}
+	ctx, span := startQuery(ctx, "JobModel.Enqueue")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err = q.QueryRowContext(ctx, query, kind, js, maxAttempts).Scan(&job.ID, &job.Status, &job.RunAt, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Claim takes the next due job and leases it to the caller for lease. Jobs
+// whose lease ran out without being finished, because the worker holding them
+// died, are due again, unless that was their last attempt: those are marked
+// dead instead. SKIP LOCKED lets concurrent workers claim different jobs
+// without waiting on each other. It returns ErrNoRecordFound when no job is
+// due.
+func (m JobModel) Claim(ctx context.Context, lease time.Duration) (*Job, error) {
+	query := `
+	WITH expired AS (
+		UPDATE jobs
+		SET status = 'dead', locked_until = NULL, last_error = 'lease expired on the last attempt', updated_at = NOW()
+		WHERE status = 'running' AND locked_until < NOW() AND attempts >= max_attempts
+	)
+	UPDATE jobs
+	SET status = 'running', attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $1), updated_at = NOW()
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE (status = 'pending' AND run_at <= NOW()) OR (status = 'running' AND locked_until < NOW() AND attempts < max_attempts)
+		ORDER BY run_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, kind, payload, status, attempts, max_attempts, run_at, last_error, created_at, updated_at`
+	var job Job
+	ctx, span := startQuery(ctx, "JobModel.Claim")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, lease.Seconds()).Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+	return &job, nil
+}
+
+// Complete marks a claimed job as done. The payload is cleared as it is no
+// longer needed.
+func (m JobModel) Complete(ctx context.Context, id int64) error {
+	query := `
+	UPDATE jobs
+	SET status = 'succeeded', payload = '{}', locked_until = NULL, last_error = '', updated_at = NOW()
+	WHERE id = $1`
+	ctx, span := startQuery(ctx, "JobModel.Complete")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Fail records a failed attempt. The job runs again after backoff unless it
+// has used up its attempts, in which case it is dead and only runs again if
+// retried by hand. It returns the job's new status.
+func (m JobModel) Fail(ctx context.Context, id int64, cause error, backoff time.Duration) (string, error) {
+	query := `
+	UPDATE jobs
+	SET status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+	run_at = NOW() + make_interval(secs => $3), locked_until = NULL, last_error = $2, updated_at = NOW()
+	WHERE id = $1
+	RETURNING status`
+	var status string
+	ctx, span := startQuery(ctx, "JobModel.Fail")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id, cause.Error(), backoff.Seconds()).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrNoRecordFound
+		default:
+			return "", err
+		}
+	}
+	return status, nil
+}
+
+// Retry gives a dead job a fresh set of attempts, starting now.
+func (m JobModel) Retry(ctx context.Context, id int64) (*Job, error) {
+	query := `
+	UPDATE jobs
+	SET status = 'pending', attempts = 0, run_at = NOW(), updated_at = NOW()
+	WHERE id = $1 AND status = 'dead'
+	RETURNING id, kind, status, attempts, max_attempts, run_at, last_error, created_at, updated_at`
+	var job Job
+	ctx, span := startQuery(ctx, "JobModel.Retry")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+	return &job, nil
+}
+
+// Backlog counts the jobs that are due but not claimed by a worker, and how
+// long the oldest of them has been waiting.
+func (m JobModel) Backlog(ctx context.Context) (int, time.Duration, error) {
+	query := `
+	SELECT count(*), COALESCE(EXTRACT(EPOCH FROM NOW() - min(run_at)), 0)
+	FROM jobs
+	WHERE status = 'pending' AND run_at <= NOW()`
+	var count int
+	var seconds float64
+	ctx, span := startQuery(ctx, "JobModel.Backlog")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count, &seconds)
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, time.Duration(seconds * float64(time.Second)), nil
+}
+
+func (m JobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	query := `
+	SELECT id, kind, status, attempts, max_attempts, run_at, last_error, created_at, updated_at
+	FROM jobs
+	WHERE id = $1`
+	var job Job
+	ctx, span := startQuery(ctx, "JobModel.Get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecordFound
+		default:
+			return nil, err
+		}
+	}
+	return &job, nil
+}
+
+// GetAll lists jobs, optionally only those with status.
+func (m JobModel) GetAll(ctx context.Context, status string, filters Filters) ([]*Job, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, kind, status, attempts, max_attempts, run_at, last_error, created_at, updated_at
+	FROM jobs
+	WHERE (status = $1 OR $1 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	ctx, span := startQuery(ctx, "JobModel.GetAll")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	jobs := []*Job{}
+	for rows.Next() {
+		var job Job
+		err := rows.Scan(
+			&totalRecords,
+			&job.ID,
+			&job.Kind,
+			&job.Status,
+			&job.Attempts,
+			&job.MaxAttempts,
+			&job.RunAt,
+			&job.LastError,
+			&job.CreatedAt,
+			&job.UpdatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		jobs = append(jobs, &job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return jobs, metadata, nil
+}
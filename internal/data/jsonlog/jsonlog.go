@@ -2,17 +2,22 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,60 +25,199 @@ const (
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 
 }
 
+// ParseLevel accepts the level names in any case, e.g. "debug" or "WARN".
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelOff, fmt.Errorf("unknown log level %q", s)
+}
+
+// sampler drops repeats of the same message: within each interval the first
+// `first` occurrences of a level and message are written, then every
+// `thereafter`th one.
+type sampler struct {
+	interval   time.Duration
+	first      uint64
+	thereafter uint64
+
+	mu      sync.Mutex
+	resetAt time.Time
+	counts  map[string]uint64
+}
+
+func (s *sampler) allow(level Level, message string) bool {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.After(s.resetAt) {
+		s.counts = make(map[string]uint64)
+		s.resetAt = now.Add(s.interval)
+	}
+	key := level.String() + "\x00" + message
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.first {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.first)%s.thereafter == 0
+}
+
+// core is shared by a logger and all the child loggers made from it with
+// With, so they write whole lines to the same output and obey the same
+// settings.
+type core struct {
+	out        io.Writer
+	mu         sync.Mutex
+	minLevel   atomic.Int32
+	traceLevel atomic.Int32
+	sampler    atomic.Pointer[sampler]
+}
+
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	core      *core
+	fields    map[string]any
+	unsampled bool
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+	c := &core{out: out}
+	c.minLevel.Store(int32(minLevel))
+	c.traceLevel.Store(int32(LevelError))
+	return &Logger{core: c}
+}
+
+// SetLevel changes the minimum level written. It is safe to call while the
+// logger is in use.
+func (l *Logger) SetLevel(level Level) {
+	l.core.minLevel.Store(int32(level))
+}
+
+func (l *Logger) Level() Level {
+	return Level(l.core.minLevel.Load())
+}
+
+// SetTraceLevel sets the level from which a stack trace is attached to log
+// entries. LevelOff disables stack traces.
+func (l *Logger) SetTraceLevel(level Level) {
+	l.core.traceLevel.Store(int32(level))
+}
+
+// SetSampling limits repeated messages below LevelError; see sampler. A zero
+// interval turns sampling off.
+func (l *Logger) SetSampling(interval time.Duration, first, thereafter uint64) {
+	if interval <= 0 {
+		l.core.sampler.Store(nil)
+		return
+	}
+	l.core.sampler.Store(&sampler{interval: interval, first: first, thereafter: thereafter})
+}
+
+// With returns a child logger which adds fields to the properties of every
+// entry it writes.
+func (l *Logger) With(fields map[string]any) *Logger {
+	merged := make(map[string]any, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
 	}
+	return &Logger{core: l.core, fields: merged, unsampled: l.unsampled}
+}
+
+// Unsampled returns a child logger whose entries are always written, for
+// messages such as access logs which repeat by design and must not be
+// sampled away.
+func (l *Logger) Unsampled() *Logger {
+	return &Logger{core: l.core, fields: l.fields, unsampled: true}
+}
+
+func (l *Logger) PrintDebug(message string, properties map[string]any) {
+	l.print(LevelDebug, message, properties)
 }
 
-func (l *Logger) PrintInfo(message string, properties map[string]string) {
+func (l *Logger) PrintInfo(message string, properties map[string]any) {
 	l.print(LevelInfo, message, properties)
 }
 
-func (l *Logger) PrintError(err error, properties map[string]string) {
+func (l *Logger) PrintWarn(message string, properties map[string]any) {
+	l.print(LevelWarn, message, properties)
+}
+
+func (l *Logger) PrintError(err error, properties map[string]any) {
 	l.print(LevelError, err.Error(), properties)
 }
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
+func (l *Logger) PrintFatal(err error, properties map[string]any) {
 	l.print(LevelFatal, err.Error(), properties)
 	os.Exit(1)
 }
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
+// propertyValue converts values which don't marshal usefully on their own:
+// errors would become {} and durations a number of nanoseconds.
+func propertyValue(v any) any {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+func (l *Logger) print(level Level, message string, properties map[string]any) (int, error) {
+	if level < l.Level() {
+		return 0, nil
+	}
+	if s := l.core.sampler.Load(); s != nil && level < LevelError && !l.unsampled && !s.allow(level, message) {
 		return 0, nil
 	}
+	var merged map[string]any
+	if len(l.fields)+len(properties) > 0 {
+		merged = make(map[string]any, len(l.fields)+len(properties))
+		for k, v := range l.fields {
+			merged[k] = propertyValue(v)
+		}
+		for k, v := range properties {
+			merged[k] = propertyValue(v)
+		}
+	}
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
 		Time:       time.Now().UTC().Format(time.RFC3339),
 		Message:    message,
-		Properties: properties,
+		Properties: merged,
 	}
-	if level >= LevelError {
+	if traceLevel := Level(l.core.traceLevel.Load()); traceLevel < LevelOff && level >= traceLevel {
 		aux.Trace = string(debug.Stack())
 	}
 	var line []byte
@@ -81,9 +225,9 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	if err != nil {
 		line = []byte(LevelError.String() + ": unable to marshall log message: " + err.Error())
 	}
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	return l.out.Write(append(line, '\n'))
+	l.core.mu.Lock()
+	defer l.core.mu.Unlock()
+	return l.core.out.Write(append(line, '\n'))
 }
 
 func (l *Logger) Write(message []byte) (n int, err error) {
@@ -0,0 +1,100 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type entry struct {
+	Level      string         `json:"level"`
+	Message    string         `json:"message"`
+	Properties map[string]any `json:"properties"`
+	Trace      string         `json:"trace"`
+}
+
+func readEntries(t *testing.T, buf *bytes.Buffer) []entry {
+	var entries []entry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var e entry
+		assert.NoError(t, json.Unmarshal([]byte(line), &e))
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := New(buf, LevelInfo)
+	child := logger.With(map[string]any{"component": "test"})
+
+	logger.PrintDebug("hidden", nil)
+	child.PrintWarn("slow", map[string]any{"took": 1500 * time.Millisecond, "rows": 3, "err": errors.New("boom")})
+	logger.SetLevel(LevelDebug)
+	logger.PrintDebug("shown", nil)
+
+	entries := readEntries(t, buf)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "WARN", entries[0].Level)
+	assert.Equal(t, map[string]any{"component": "test", "took": "1.5s", "rows": float64(3), "err": "boom"}, entries[0].Properties)
+	assert.Empty(t, entries[0].Trace)
+	assert.Equal(t, "shown", entries[1].Message)
+}
+
+func TestLogger_TraceLevel(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := New(buf, LevelInfo)
+	logger.PrintError(errors.New("with trace"), nil)
+	logger.SetTraceLevel(LevelOff)
+	logger.PrintError(errors.New("without trace"), nil)
+
+	entries := readEntries(t, buf)
+	assert.NotEmpty(t, entries[0].Trace)
+	assert.Empty(t, entries[1].Trace)
+}
+
+func TestLogger_Sampling(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := New(buf, LevelInfo)
+	logger.SetSampling(time.Hour, 2, 3)
+
+	access := logger.With(map[string]any{"component": "http"}).Unsampled()
+	for i := 0; i < 10; i++ {
+		logger.PrintInfo("noisy", nil)
+		logger.PrintError(errors.New("never sampled"), nil)
+		access.PrintInfo("request", nil)
+	}
+
+	var info, errs, requests int
+	for _, e := range readEntries(t, buf) {
+		switch {
+		case e.Message == "request":
+			requests++
+		case e.Level == "INFO":
+			info++
+		default:
+			errs++
+		}
+	}
+	// Occurrences 1, 2, then 5 and 8.
+	assert.Equal(t, 4, info)
+	assert.Equal(t, 10, errs)
+	assert.Equal(t, 10, requests)
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("warn")
+	assert.NoError(t, err)
+	assert.Equal(t, LevelWarn, level)
+
+	_, err = ParseLevel("loud")
+	assert.Error(t, err)
+}
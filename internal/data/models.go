@@ -1,8 +1,11 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+
+	"sulfur.test.net/internal/tracing"
 )
 
 var (
@@ -11,17 +14,44 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies       MovieModel
+	Users        UserModel
+	Tokens       TokenModel
+	Permissions  PermissionModel
+	Genres       GenreModel
+	Translations TranslationModel
+	RateLimits   RateLimitModel
+	Jobs         JobModel
+	Suppressions SuppressionModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Permissions: PermissionModel{DB: db},
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		Permissions:  PermissionModel{DB: db},
+		Movies:       MovieModel{DB: db},
+		Users:        UserModel{DB: db},
+		Tokens:       TokenModel{DB: db},
+		Genres:       GenreModel{DB: db},
+		Translations: TranslationModel{DB: db},
+		RateLimits:   RateLimitModel{DB: db},
+		Jobs:         JobModel{DB: db},
+		Suppressions: SuppressionModel{DB: db},
 	}
 }
+
+// queryer runs statements on either a *sql.DB or a *sql.Tx, so that model
+// methods with a Tx variant can share one implementation.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// startQuery starts a trace span for a model method, named after the method
+// so slow statements can be told apart.
+func startQuery(ctx context.Context, name string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "db "+name)
+	span.SetKind("client")
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.statement.name", name)
+	return ctx, span
+}
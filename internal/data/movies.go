@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -12,40 +14,96 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`                // Unique integer ID for the movie
-	CreatedAt time.Time `json:"-"`                 // Timestamp for when the movie is added to our database
-	Title     string    `json:"tittle"`            // Movie title
-	Year      int32     `json:"year,omitempty"`    // Movie release year
-	Runtime   Runtime   `json:"runtime,omitempty"` // Movie runtime (in minutes)
-	Genres    []string  `json:"genres,omitempty"`  // Slice of genres for the movie (romance, comedy, etc.)
-	Version   int32     `json:"version"`           // The version number starts at 1 and will be incremented each
+	ID        int64     `json:"id"`                 // Unique integer ID for the movie
+	CreatedAt time.Time `json:"-"`                  // Timestamp for when the movie is added to our database
+	Title     string    `json:"tittle"`             // Movie title
+	Synopsis  string    `json:"synopsis,omitempty"` // Short plot summary
+	Year      int32     `json:"year,omitempty"`     // Movie release year
+	Runtime   Runtime   `json:"runtime,omitempty"`  // Movie runtime (in minutes)
+	Genres    []string  `json:"genres,omitempty"`   // Slice of genres for the movie (romance, comedy, etc.)
+	Version   int32     `json:"version"`            // The version number starts at 1 and will be incremented each
 	// time the movie information is updated
+	PosterKey string            `json:"-"`                  // Storage key of the original poster image, empty if none
+	Poster    map[string]string `json:"poster,omitempty"`   // Poster URLs by size, filled in by the handlers
+	Language  string            `json:"language,omitempty"` // Language of Title and Synopsis after negotiation
+	// Trigram similarity of Title to the search term, only set by fuzzy searches
+	Similarity float64 `json:"similarity,omitempty"`
+}
+
+type Suggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
 }
 
 type MovieModel struct {
 	DB *sql.DB
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 
 	query := `
-INSERT INTO movies (title, year, runtime, genres)
-VALUES ($1, $2, $3, $4)
+INSERT INTO movies (title, synopsis, year, runtime, genres)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING id, created_at, version`
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{movie.Title, movie.Synopsis, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	ctx, span := startQuery(ctx, "MovieModel.Insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+
+// translationSearchCondition matches translations against the title search
+// in $1. The index on movie_translations is per row's search_config, and a
+// tsquery built from a column can't use it, so there is one branch per
+// configuration, each with a constant query.
+var translationSearchCondition = func() string {
+	seen := make(map[string]bool)
+	var configs []string
+	for _, config := range TextSearchConfigs {
+		if !seen[config] {
+			seen[config] = true
+			configs = append(configs, config)
+		}
+	}
+	sort.Strings(configs)
+	branches := make([]string, len(configs))
+	for i, config := range configs {
+		branches[i] = fmt.Sprintf(`(search_config = '%[1]s' AND to_tsvector(search_config, title || ' ' || synopsis) @@ plainto_tsquery('%[1]s', $1))`, config)
+	}
+	return strings.Join(branches, "\n\t\t\tOR ")
+}()
+
+// movieFilterCondition is the WHERE clause shared by the list queries. It
+// expects the title search in $1 and the genres in $2. With fuzzy set, titles
+// are matched by trigram word similarity instead of full-text search.
+func movieFilterCondition(fuzzy bool) string {
+	titleCondition := `to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = ''
+		OR movies.id IN (
+			SELECT movie_id FROM movie_translations
+			WHERE ` + translationSearchCondition + `
+		)`
+	if fuzzy {
+		titleCondition = `lower($1) <% lower(title) OR $1 = ''`
+	}
+	return fmt.Sprintf(`(%s)
+	AND (genres @> $2 OR $2 = '{}')`, titleCondition)
+}
+
+func (m MovieModel) GetAll(ctx context.Context, title string, genres []string, fuzzy bool, filters Filters) ([]*Movie, Metadata, error) {
+	similarity := "0::real"
+	if fuzzy {
+		similarity = "word_similarity(lower($1), lower(title))"
+	}
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	SELECT count(*) OVER(), id, created_at, title, synopsis, year, runtime, genres, version, poster, %s AS similarity
 	FROM movies
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}')
+	WHERE %s
 	ORDER BY %s %s,id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	LIMIT $3 OFFSET $4`, similarity, movieFilterCondition(fuzzy), filters.sortColumn(), filters.sortDirection())
+	ctx, span := startQuery(ctx, "MovieModel.GetAll")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
 
@@ -64,10 +122,13 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.ID,
 			&movie.CreatedAt,
 			&movie.Title,
+			&movie.Synopsis,
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.PosterKey,
+			&movie.Similarity,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -81,26 +142,66 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	return movies, metadata, nil
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// Suggest returns up to limit titles where the prefix starts the title or one
+// of its words, most similar first. Both LIKE patterns are served by the
+// trigram index on lower(title).
+func (m MovieModel) Suggest(ctx context.Context, prefix string, limit int) ([]*Suggestion, error) {
+	query := `
+	SELECT id, title
+	FROM movies
+	WHERE lower(title) LIKE $1 OR lower(title) LIKE $2
+	ORDER BY similarity(lower(title), $3) DESC, title ASC
+	LIMIT $4`
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(prefix))
+	args := []any{escaped + "%", "% " + escaped + "%", strings.ToLower(prefix), limit}
+	ctx, span := startQuery(ctx, "MovieModel.Suggest")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	suggestions := []*Suggestion{}
+	for rows.Next() {
+		var suggestion Suggestion
+		err := rows.Scan(&suggestion.ID, &suggestion.Title)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 
 	if id < 1 {
 		return nil, ErrNoRecordFound
 	}
 	query := `
-SELECT id, created_at, title, year, runtime, genres, version
+SELECT id, created_at, title, synopsis, year, runtime, genres, version, poster
 FROM movies
 WHERE id = $1`
 	var movie Movie
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "MovieModel.Get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
+		&movie.Synopsis,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.PosterKey,
 	)
 	if err != nil {
 		switch {
@@ -113,21 +214,25 @@ WHERE id = $1`
 	return &movie, nil
 
 }
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
 	query := `
 UPDATE movies
-SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-WHERE id = $5 AND version = $6
+SET title = $1, synopsis = $2, year = $3, runtime = $4, genres = $5, poster = $6, version = version + 1
+WHERE id = $7 AND version = $8
 RETURNING version`
 	args := []any{
 		movie.Title,
+		movie.Synopsis,
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.PosterKey,
 		movie.ID,
 		movie.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "MovieModel.Update")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
@@ -141,12 +246,14 @@ RETURNING version`
 	}
 	return nil
 }
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrNoRecordFound
 	}
 	query := `DELETE FROM movies WHERE id = $1`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "MovieModel.Delete")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
@@ -162,9 +269,12 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// ValidateMovie also rewrites movie.Genres in place so that aliases from the
+// catalogue are stored under their canonical slug.
+func ValidateMovie(v *validator.Validator, movie *Movie, genres Genres) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not to be more than 500 bytes long")
+	v.Check(len(movie.Synopsis) <= 5000, "synopsis", "must not to be more than 5000 bytes long")
 	v.Check(movie.Year != 0, "year", "must be provided")
 	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
 	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not to be in the future")
@@ -176,5 +286,14 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 
+	for i := range movie.Genres {
+		slug, ok := genres.Canonical(movie.Genres[i])
+		if !ok {
+			v.AddError("genres", fmt.Sprintf("unknown genre %q", movie.Genres[i]))
+			continue
+		}
+		movie.Genres[i] = slug
+	}
+
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
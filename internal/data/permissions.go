@@ -23,18 +23,30 @@ type PermissionModel struct {
 	DB *sql.DB
 }
 
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	return m.addForUser(ctx, m.DB, userID, codes)
+}
+
+// AddForUserTx is AddForUser as part of tx.
+func (m PermissionModel) AddForUserTx(ctx context.Context, tx *sql.Tx, userID int64, codes ...string) error {
+	return m.addForUser(ctx, tx, userID, codes)
+}
+
+func (m PermissionModel) addForUser(ctx context.Context, q queryer, userID int64, codes []string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code=ANY($2)
+		ON CONFLICT DO NOTHING
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "PermissionModel.AddForUser")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
 
-func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
@@ -42,7 +54,9 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		INNER JOIN users ON users_permissions.user_id=users.id
 		WHERE users.id =$1
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "PermissionModel.GetAllForUser")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	rows, err := m.DB.QueryContext(ctx, query, userID)
 	if err != nil {
@@ -63,3 +77,34 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	}
 	return permissions, nil
 }
+
+// GetAll returns every permission code that can be granted.
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY id
+	`
+	ctx, span := startQuery(ctx, "PermissionModel.GetAll")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RateLimitModel struct {
+	DB *sql.DB
+}
+
+// Take refills the token bucket stored under key for the time elapsed since
+// it was last used and removes one token from it if there is one, all in a
+// single statement so concurrent replicas can't both spend the last token.
+// It returns the tokens left and whether a token was taken.
+func (m RateLimitModel) Take(key string, rps float64, burst int, timeout time.Duration) (float64, bool, error) {
+	query := `
+	INSERT INTO rate_limits AS rl (key, tokens, allowed, updated_at)
+	VALUES ($1, $3::float8 - 1, true, now())
+	ON CONFLICT (key) DO UPDATE
+	SET tokens = CASE
+		WHEN LEAST($3::float8, rl.tokens + EXTRACT(EPOCH FROM now() - rl.updated_at)::float8 * $2::float8) >= 1
+		THEN LEAST($3::float8, rl.tokens + EXTRACT(EPOCH FROM now() - rl.updated_at)::float8 * $2::float8) - 1
+		ELSE LEAST($3::float8, rl.tokens + EXTRACT(EPOCH FROM now() - rl.updated_at)::float8 * $2::float8)
+	END,
+	allowed = LEAST($3::float8, rl.tokens + EXTRACT(EPOCH FROM now() - rl.updated_at)::float8 * $2::float8) >= 1,
+	updated_at = now()
+	RETURNING tokens, allowed`
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var tokens float64
+	var allowed bool
+	err := m.DB.QueryRowContext(ctx, query, key, rps, burst).Scan(&tokens, &allowed)
+	if err != nil {
+		return 0, false, err
+	}
+	return tokens, allowed, nil
+}
+
+// Prune deletes buckets that haven't been used for longer than olderThan.
+func (m RateLimitModel) Prune(olderThan time.Duration) (int64, error) {
+	query := `DELETE FROM rate_limits WHERE updated_at < now() - make_interval(secs => $1)`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
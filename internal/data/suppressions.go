@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	SuppressionBounce    = "bounce"
+	SuppressionComplaint = "complaint"
+)
+
+// Suppression marks an address we must stop emailing because mail to it
+// bounced permanently or its owner reported it as spam.
+type Suppression struct {
+	Email       string    `json:"email"`
+	Reason      string    `json:"reason"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type SuppressionModel struct {
+	DB *sql.DB
+}
+
+// Insert records a suppression. An address that is already suppressed keeps
+// its original entry.
+func (m SuppressionModel) Insert(ctx context.Context, suppression *Suppression) error {
+	query := `
+	INSERT INTO email_suppressions (email, reason, description)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (email) DO NOTHING`
+	ctx, span := startQuery(ctx, "SuppressionModel.Insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, suppression.Email, suppression.Reason, suppression.Description)
+	return err
+}
+
+func (m SuppressionModel) Exists(ctx context.Context, email string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM email_suppressions WHERE email = $1)`
+	var exists bool
+	ctx, span := startQuery(ctx, "SuppressionModel.Exists")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&exists)
+	return exists, err
+}
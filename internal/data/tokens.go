@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"encoding/hex"
 	"time"
 
 	"sulfur.test.net/internal/data/validator"
@@ -14,6 +15,9 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "aunthentication"
+	// ScopeAPIKey tokens are long-lived credentials for partner integrations,
+	// sent as "Authorization: ApiKey <token>".
+	ScopeAPIKey = "api-key"
 )
 
 type Token struct {
@@ -41,6 +45,13 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
+// TokenID returns a short identifier for a token that is safe to log and to
+// use as a map key, derived from its hash rather than the plaintext.
+func TokenID(tokenPlaintext string) string {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hex.EncodeToString(hash[:8])
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
@@ -51,37 +62,79 @@ type TokenModel struct {
 	DB *sql.DB
 }
 
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// NewTx is New as part of tx.
+func (m TokenModel) NewTx(ctx context.Context, tx *sql.Tx, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
-	err = m.Insert(token)
+	err = m.insert(ctx, tx, token)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
+	return m.insert(ctx, m.DB, token)
+}
+
+func (m TokenModel) insert(ctx context.Context, q queryer, token *Token) error {
 	query := `
 		INSERT INTO tokens (hash,user_id,expiry,scope)
 		VALUES ($1,$2,$3,$4)
 	`
 	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "TokenModel.Insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := q.ExecContext(ctx, query, args...)
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 	DELETE FROM tokens
 	WHERE scope = $1 and user_id=$2
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "TokenModel.DeleteAllForUser")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	args := []any{scope, userID}
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
+
+// DeleteExpired deletes up to limit tokens of any scope past their expiry,
+// oldest first, and returns how many it deleted. Limiting the batch keeps
+// each statement short when there is a large backlog.
+func (m TokenModel) DeleteExpired(ctx context.Context, limit int) (int64, error) {
+	query := `
+	DELETE FROM tokens
+	WHERE hash IN (
+		SELECT hash FROM tokens
+		WHERE expiry < $1
+		ORDER BY expiry
+		LIMIT $2
+	)
+	`
+	ctx, span := startQuery(ctx, "TokenModel.DeleteExpired")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
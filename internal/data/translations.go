@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"sulfur.test.net/internal/data/validator"
+)
+
+// TextSearchConfigs maps the languages we accept translations for to the
+// PostgreSQL text search configuration used to index them. Languages without
+// a stemmer in PostgreSQL use 'simple'.
+var TextSearchConfigs = map[string]string{
+	"da": "danish",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"hu": "hungarian",
+	"it": "italian",
+	"kk": "simple",
+	"nl": "dutch",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
+	"uk": "simple",
+}
+
+type Translation struct {
+	MovieID  int64  `json:"movie_id"`
+	Language string `json:"language"`
+	Title    string `json:"title"`
+	Synopsis string `json:"synopsis,omitempty"`
+	Version  int32  `json:"version"`
+}
+
+func ValidateTranslation(v *validator.Validator, translation *Translation) {
+	_, supported := TextSearchConfigs[translation.Language]
+	v.Check(supported, "language", "is not a supported language")
+	v.Check(translation.Title != "", "title", "must be provided")
+	v.Check(len(translation.Title) <= 500, "title", "must not to be more than 500 bytes long")
+	v.Check(len(translation.Synopsis) <= 5000, "synopsis", "must not to be more than 5000 bytes long")
+}
+
+type TranslationModel struct {
+	DB *sql.DB
+}
+
+func (m TranslationModel) Upsert(ctx context.Context, translation *Translation) error {
+	query := `
+	INSERT INTO movie_translations (movie_id, language, search_config, title, synopsis)
+	VALUES ($1, $2, $3::regconfig, $4, $5)
+	ON CONFLICT (movie_id, language) DO UPDATE
+	SET search_config = EXCLUDED.search_config, title = EXCLUDED.title, synopsis = EXCLUDED.synopsis,
+	version = movie_translations.version + 1
+	RETURNING version`
+	args := []any{
+		translation.MovieID,
+		translation.Language,
+		TextSearchConfigs[translation.Language],
+		translation.Title,
+		translation.Synopsis,
+	}
+	ctx, span := startQuery(ctx, "TranslationModel.Upsert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&translation.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "movie_translations" violates foreign key constraint "movie_translations_movie_id_fkey"`:
+			return ErrNoRecordFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m TranslationModel) GetAllForMovie(ctx context.Context, movieID int64) ([]*Translation, error) {
+	return m.GetForMovies(ctx, []int64{movieID}, nil)
+}
+
+// GetForMovies returns the translations of the given movies, restricted to
+// languages unless it is empty.
+func (m TranslationModel) GetForMovies(ctx context.Context, movieIDs []int64, languages []string) ([]*Translation, error) {
+	query := `
+	SELECT movie_id, language, title, synopsis, version
+	FROM movie_translations
+	WHERE movie_id = ANY($1)
+	AND (language = ANY($2) OR $2 = '{}')
+	ORDER BY movie_id, language`
+	ctx, span := startQuery(ctx, "TranslationModel.GetForMovies")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if languages == nil {
+		languages = []string{}
+	}
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), pq.Array(languages))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	translations := []*Translation{}
+	for rows.Next() {
+		var translation Translation
+		err := rows.Scan(
+			&translation.MovieID,
+			&translation.Language,
+			&translation.Title,
+			&translation.Synopsis,
+			&translation.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		translations = append(translations, &translation)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return translations, nil
+}
+
+func (m TranslationModel) Delete(ctx context.Context, movieID int64, language string) error {
+	query := `DELETE FROM movie_translations WHERE movie_id = $1 AND language = $2`
+	ctx, span := startQuery(ctx, "TranslationModel.Delete")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, movieID, language)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecordFound
+	}
+	return nil
+}
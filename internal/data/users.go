@@ -27,6 +27,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
+	Language  string    `json:"language,omitempty"`
 	Version   int       `json:"-"`
 }
 type password struct {
@@ -68,6 +69,12 @@ func ValidatePassowrdPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "password", "must be no more than 72 bytes long")
 }
 
+// ValidateLanguage checks that language, the one a user gets emails in, is
+// empty for the default or one of supported.
+func ValidateLanguage(v *validator.Validator, language string, supported []string) {
+	v.Check(language == "" || validator.PermittedValue(language, supported...), "language", "is not a supported language")
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not to be more than 500 bytes long")
@@ -84,16 +91,27 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
+	return m.insert(ctx, m.DB, user)
+}
+
+// InsertTx is Insert as part of tx.
+func (m UserModel) InsertTx(ctx context.Context, tx *sql.Tx, user *User) error {
+	return m.insert(ctx, tx, user)
+}
+
+func (m UserModel) insert(ctx context.Context, q queryer, user *User) error {
 	query := `
-	INSERT INTO users (name,email,password_hash,activated)
-	VALUES ($1,$2,$3,$4)
+	INSERT INTO users (name,email,password_hash,activated,language)
+	VALUES ($1,$2,$3,$4,$5)
 	RETURNING id,created_at,version
 	`
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.Language}
+	ctx, span := startQuery(ctx, "UserModel.Insert")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -105,14 +123,16 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-	SELECT id,created_at, name,email,password_hash,activated,version
+	SELECT id,created_at, name,email,password_hash,activated,language,version
 	FROM users
 	WHERE email = $1
 	`
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "UserModel.GetByEmail")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
@@ -121,6 +141,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Language,
 		&user.Version,
 	)
 	if err != nil {
@@ -134,11 +155,11 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 	UPDATE users
-	SET name = $1, email = $2, password_hash=$3, activated = $4, version=version+1
-	WHERE id = $5 AND version = $6
+	SET name = $1, email = $2, password_hash=$3, activated = $4, language = $5, version=version+1
+	WHERE id = $6 AND version = $7
 	RETURNING version
 	`
 	args := []any{
@@ -146,10 +167,13 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Language,
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "UserModel.Update")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
@@ -165,9 +189,9 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
-	query := `SELECT users.id, users.created_at,users.name,users.email,users.password_hash,users.activated,users.version
+	query := `SELECT users.id, users.created_at,users.name,users.email,users.password_hash,users.activated,users.language,users.version
 			FROM users
 			INNER JOIN tokens
 			ON users.id=tokens.user_id
@@ -177,7 +201,9 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuery(ctx, "UserModel.GetForToken")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID,
@@ -186,6 +212,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Language,
 		&user.Version,
 	)
 	if err != nil {
@@ -0,0 +1,146 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	WebP = "image/webp"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTooLarge          = errors.New("image dimensions are too large")
+)
+
+// MaxPixels bounds width*height of decoded images so that a small, highly
+// compressed upload can't make us allocate gigabytes.
+const MaxPixels = 40_000_000
+
+// Sniff reports the content type of an image from its leading bytes, only
+// accepting the formats we store.
+func Sniff(head []byte) (string, error) {
+	contentType := http.DetectContentType(head)
+	switch contentType {
+	case JPEG, PNG, WebP:
+		return contentType, nil
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+func Extension(contentType string) string {
+	switch contentType {
+	case JPEG:
+		return ".jpg"
+	case PNG:
+		return ".png"
+	case WebP:
+		return ".webp"
+	default:
+		return ""
+	}
+}
+
+// CanResize reports whether thumbnails can be produced for the content type.
+// The standard library has no WebP decoder, so WebP posters are served as
+// uploaded.
+func CanResize(contentType string) bool {
+	return contentType == JPEG || contentType == PNG
+}
+
+func Decode(r io.ReadSeeker, contentType string) (image.Image, error) {
+	if !CanResize(contentType) {
+		return nil, ErrUnsupportedFormat
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	switch contentType {
+	case JPEG:
+		return jpeg.Decode(r)
+	default:
+		return png.Decode(r)
+	}
+}
+
+func Encode(w io.Writer, img image.Image, contentType string) error {
+	switch contentType {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case PNG:
+		return png.Encode(w, img)
+	default:
+		return ErrUnsupportedFormat
+	}
+}
+
+// RGBA converts img to the form Resize takes. Convert once and resize the
+// result as often as needed: a full size RGBA copy of a large upload is
+// expensive.
+func RGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// Resize scales src down to the given width keeping its aspect ratio, using
+// an area average of the source pixels covered by each destination pixel.
+// Images already narrower than width are returned unchanged. To make several
+// sizes, resize each from the next larger one rather than from the original.
+func Resize(src *image.RGBA, width int) *image.RGBA {
+	bounds := src.Bounds()
+	if width <= 0 || bounds.Dx() <= width {
+		return src
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * bounds.Dy() / height
+		y1 := max((y+1)*bounds.Dy()/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * bounds.Dx() / width
+			x1 := max((x+1)*bounds.Dx()/width, x0+1)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
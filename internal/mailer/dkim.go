@@ -0,0 +1,165 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dkimHeaders are signed when present. From must always be signed.
+var dkimHeaders = []string{
+	"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type",
+	"List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMSigner adds a DKIM-Signature header (RFC 6376) to outgoing messages,
+// using relaxed canonicalization for headers and body. The public key must be
+// published in DNS at <selector>._domainkey.<domain>.
+type DKIMSigner struct {
+	domain   string
+	selector string
+	key      crypto.Signer
+}
+
+// NewDKIMSigner accepts a PEM encoded RSA (PKCS #1 or #8) or Ed25519 (PKCS
+// #8) private key.
+func NewDKIMSigner(domain, selector string, pemKey []byte) (*DKIMSigner, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM data in private key")
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &DKIMSigner{domain: domain, selector: selector, key: key}, nil
+	case ed25519.PrivateKey:
+		return &DKIMSigner{domain: domain, selector: selector, key: key}, nil
+	default:
+		return nil, fmt.Errorf("dkim: unsupported key type %T", key)
+	}
+}
+
+func (s *DKIMSigner) algorithm() string {
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		return "ed25519-sha256"
+	}
+	return "rsa-sha256"
+}
+
+// Sign returns the message with a DKIM-Signature header prepended. The
+// message must use CRLF line endings.
+func (s *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	rawHeaders, body, found := bytes.Cut(message, []byte("\r\n\r\n"))
+	if !found {
+		return nil, errors.New("dkim: message has no body separator")
+	}
+	headers := splitHeaders(string(rawHeaders) + "\r\n")
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	var signed []string
+	var canonical strings.Builder
+	for _, name := range dkimHeaders {
+		for i := len(headers) - 1; i >= 0; i-- {
+			headerName, _, _ := strings.Cut(headers[i], ":")
+			if strings.EqualFold(strings.TrimSpace(headerName), name) {
+				canonical.WriteString(relaxedHeader(headers[i]))
+				signed = append(signed, strings.ToLower(name))
+				break
+			}
+		}
+	}
+	if len(signed) == 0 || signed[0] != "from" {
+		return nil, errors.New("dkim: message has no From header")
+	}
+
+	signature := fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		s.algorithm(), s.domain, s.selector, time.Now().Unix(), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	canonical.WriteString(strings.TrimSuffix(relaxedHeader(signature+"\r\n"), "\r\n"))
+
+	digest := sha256.Sum256([]byte(canonical.String()))
+	var sig []byte
+	var err error
+	switch key := s.key.(type) {
+	case ed25519.PrivateKey:
+		// RFC 8463 signs the SHA-256 digest with pure Ed25519.
+		sig = ed25519.Sign(key, digest[:])
+	default:
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("dkim: %w", err)
+		}
+	}
+
+	out := bytes.NewBufferString(signature)
+	out.WriteString(base64.StdEncoding.EncodeToString(sig))
+	out.WriteString("\r\n")
+	out.Write(message)
+	return out.Bytes(), nil
+}
+
+// splitHeaders splits a header block into fields, keeping folded
+// continuation lines with the field they belong to. Each field ends in CRLF.
+func splitHeaders(block string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(block, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+var wspRX = regexp.MustCompile(`[ \t]+`)
+
+// relaxedHeader applies the relaxed header canonicalization of RFC 6376
+// section 3.4.2 to one field.
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = wspRX.ReplaceAllString(value, " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(value) + "\r\n"
+}
+
+// relaxedBody applies the relaxed body canonicalization of RFC 6376 section
+// 3.4.4.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(wspRX.ReplaceAllString(lines[i], " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
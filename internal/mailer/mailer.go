@@ -2,62 +2,205 @@ package mailer
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"embed"
-	"text/template"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/mail"
+	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-mail/mail/v2"
+	gomail "github.com/go-mail/mail/v2"
 )
 
 //go:embed "templates"
 var templateFS embed.FS
 
+// ErrSuppressed is returned by Send for a recipient on the suppression list.
+var ErrSuppressed = errors.New("recipient is suppressed")
+
+// Suppressions reports whether an address must not be mailed, e.g. because
+// mail to it bounced or its owner complained.
+type Suppressions interface {
+	Exists(ctx context.Context, email string) (bool, error)
+}
+
+// Message is a rendered email, ready for a Transport.
+type Message struct {
+	ID        string
+	Date      time.Time
+	From      string
+	To        string
+	Subject   string
+	Template  string
+	PlainBody string
+	HTMLBody  string
+	// Unsubscribe is the one-click unsubscribe URL for mail that isn't
+	// transactional, set from the template's optional "unsubscribeURL" block.
+	Unsubscribe string
+
+	signer *DKIMSigner
+}
+
+func (m *Message) mime() *gomail.Message {
+	msg := gomail.NewMessage()
+	msg.SetHeader("To", m.To)
+	msg.SetHeader("From", m.From)
+	msg.SetHeader("Subject", m.Subject)
+	if m.ID != "" {
+		msg.SetHeader("Message-ID", "<"+m.ID+">")
+	}
+	if !m.Date.IsZero() {
+		msg.SetDateHeader("Date", m.Date)
+	}
+	if m.Unsubscribe != "" {
+		// RFC 8058 one-click unsubscribe, which large providers expect from
+		// bulk senders.
+		msg.SetHeader("List-Unsubscribe", "<"+m.Unsubscribe+">")
+		msg.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	msg.SetBody("text/plain", m.PlainBody)
+	msg.AddAlternative("text/html", m.HTMLBody)
+	return msg
+}
+
+// WriteTo writes the message in RFC 5322 form, as it would be sent over SMTP,
+// DKIM signed if the mailer has a signer.
+func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	buf := new(bytes.Buffer)
+	_, err := m.mime().WriteTo(buf)
+	if err != nil {
+		return 0, err
+	}
+	raw := buf.Bytes()
+	if m.signer != nil {
+		raw, err = m.signer.Sign(raw)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.Write(raw)
+	return int64(n), err
+}
+
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport    Transport
+	templates    *Registry
+	sender       *atomic.Pointer[sender]
+	signer       *DKIMSigner
+	suppressions Suppressions
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
+type sender struct {
+	address string
+	domain  string
+}
+
+// Locales lists the languages the embedded templates are available in,
+// without needing a Mailer.
+func Locales() ([]string, error) {
+	templates, err := embeddedRegistry()
+	if err != nil {
+		return nil, err
 	}
+	return templates.Locales(), nil
 }
 
-func (m Mailer) Send(recipient, templateFile string, data any) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+func embeddedRegistry() (*Registry, error) {
+	fsys, err := fs.Sub(templateFS, "templates")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return NewRegistry(fsys)
+}
+
+// New parses the embedded templates, failing if any of them is broken.
+// signer may be nil to send unsigned mail, and suppressions nil to mail every
+// address.
+func New(transport Transport, from string, signer *DKIMSigner, suppressions Suppressions) (Mailer, error) {
+	templates, err := embeddedRegistry()
+	if err != nil {
+		return Mailer{}, err
+	}
+	m := Mailer{
+		transport:    transport,
+		templates:    templates,
+		sender:       new(atomic.Pointer[sender]),
+		signer:       signer,
+		suppressions: suppressions,
+	}
+	err = m.SetSender(from)
+	if err != nil {
+		return Mailer{}, err
 	}
-	subject := new(bytes.Buffer)
+	return m, nil
+}
 
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
+// SetSender changes the From address of messages rendered from now on. It is
+// safe to call while mail is being sent.
+func (m Mailer) SetSender(from string) error {
+	address, err := mail.ParseAddress(from)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid sender: %w", err)
 	}
-	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	_, domain, _ := strings.Cut(address.Address, "@")
+	m.sender.Store(&sender{address: from, domain: domain})
+	return nil
+}
+
+// Render builds the message for a template in the locale closest to the one
+// given; an empty locale means the default language.
+func (m Mailer) Render(recipient, templateFile, locale string, data any) (*Message, error) {
+	msg, err := m.templates.Render(templateFile, locale, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-	for i := 0; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		if nil == err {
-			return nil
+	from := m.sender.Load()
+	msg.ID = hex.EncodeToString(b) + "@" + from.domain
+	msg.Date = time.Now()
+	msg.From = from.address
+	msg.To = recipient
+	msg.signer = m.signer
+	return msg, nil
+}
+
+// Send renders a template and sends it, unless the recipient is suppressed,
+// in which case it returns ErrSuppressed. Every path that sends mail goes
+// through here, so none of them can mail a suppressed address.
+func (m Mailer) Send(ctx context.Context, recipient, templateFile, locale string, data any) error {
+	if m.suppressions != nil {
+		suppressed, err := m.suppressions.Exists(ctx, recipient)
+		if err != nil {
+			return err
+		}
+		if suppressed {
+			return ErrSuppressed
 		}
 	}
-	return err
+	msg, err := m.Render(recipient, templateFile, locale, data)
+	if err != nil {
+		return err
+	}
+	// Failed sends are retried with backoff by the job queue.
+	return m.transport.Send(ctx, msg)
+}
+
+// Locales lists the languages emails can be sent in.
+func (m Mailer) Locales() []string {
+	return m.templates.Locales()
+}
+
+// Templates lists the available templates and their translations.
+func (m Mailer) Templates() map[string][]string {
+	return m.templates.Templates()
 }
@@ -0,0 +1,213 @@
+package mailer
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMailer_Send(t *testing.T) {
+	recorder := NewRecorder()
+	m, err := New(recorder, "Greenlight <no-reply@example.com>", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"en", "fr"}, m.Locales())
+
+	err = m.Send(context.Background(), "alice@example.com", "user_welcome.tmpl", "", map[string]any{"activationToken": "TOKEN", "userID": 7})
+	assert.NoError(t, err)
+	err = m.Send(context.Background(), "bob@example.com", "user_welcome.tmpl", "fr", map[string]any{"activationToken": "TOKEN", "userID": 8})
+	assert.NoError(t, err)
+	err = m.Send(context.Background(), "carol@example.com", "user_welcome.tmpl", "de", map[string]any{"activationToken": "TOKEN", "userID": 9})
+	assert.NoError(t, err)
+
+	messages := recorder.Messages()
+	assert.Len(t, messages, 3)
+	assert.Equal(t, "alice@example.com", messages[0].To)
+	assert.Equal(t, "Greenlight <no-reply@example.com>", messages[0].From)
+	assert.Equal(t, "Welcome to Greenlight!", messages[0].Subject)
+	assert.Contains(t, messages[0].PlainBody, `{"token": "TOKEN"}`)
+	assert.Contains(t, messages[0].HTMLBody, "your user ID number is 7")
+	assert.Contains(t, messages[0].HTMLBody, "<title>Welcome to Greenlight!</title>")
+	assert.Equal(t, "Bienvenue sur Greenlight !", messages[1].Subject)
+	assert.Equal(t, "Welcome to Greenlight!", messages[2].Subject)
+
+	err = m.Send(context.Background(), "alice@example.com", "missing.tmpl", "", nil)
+	assert.ErrorIs(t, err, ErrUnknownTemplate)
+}
+
+func TestNewRegistry(t *testing.T) {
+	layout := &fstest.MapFile{Data: []byte(`{{define "layout"}}<p>{{template "content" .}}</p>{{end}}`)}
+	valid := `{{define "subject"}}Hi{{end}}{{define "plainBody"}}{{.activationToken}}{{end}}{{define "htmlBody"}}{{template "layout" .}}{{end}}{{define "content"}}{{.activationToken}}{{end}}`
+
+	r, err := NewRegistry(fstest.MapFS{
+		"layouts/base.tmpl": layout,
+		"user_welcome.tmpl": {Data: []byte(valid)},
+	})
+	assert.NoError(t, err)
+	msg, err := r.Render("user_welcome.tmpl", "", map[string]any{"activationToken": "<b>"})
+	assert.NoError(t, err)
+	assert.Equal(t, "<p>&lt;b&gt;</p>", msg.HTMLBody)
+	assert.Empty(t, msg.Unsubscribe)
+
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{"syntax error", `{{define "subject"}}Hi{{end`},
+		{"missing block", `{{define "subject"}}Hi{{end}}{{define "plainBody"}}Hi{{end}}`},
+		{"unknown field", strings.ReplaceAll(valid, ".activationToken", ".activationTokn")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRegistry(fstest.MapFS{
+				"layouts/base.tmpl": layout,
+				"user_welcome.tmpl": {Data: []byte(tt.template)},
+			})
+			assert.Error(t, err)
+		})
+	}
+
+	_, err = NewRegistry(fstest.MapFS{
+		"layouts/base.tmpl":    layout,
+		"fr/user_welcome.tmpl": {Data: []byte(valid)},
+	})
+	assert.Error(t, err, "translation without a default")
+}
+
+func TestOutbox_Send(t *testing.T) {
+	dir := t.TempDir()
+	outbox, err := NewOutbox(filepath.Join(dir, "outbox"))
+	assert.NoError(t, err)
+
+	m, err := New(outbox, "no-reply@example.com", nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, m.Send(context.Background(), "alice@example.com", "user_welcome.tmpl", "", map[string]any{"activationToken": "TOKEN", "userID": 7}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "outbox", "*"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.True(t, strings.HasSuffix(files[0], ".eml"))
+
+	eml, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(eml), "To: alice@example.com")
+	assert.Contains(t, string(eml), "Subject: Welcome to Greenlight!")
+	assert.Regexp(t, `Message-ID: <[0-9a-f]{32}@example\.com>`, string(eml))
+	assert.Contains(t, string(eml), "Date: ")
+	// The welcome email is transactional, so it has no unsubscribe link.
+	assert.NotContains(t, string(eml), "List-Unsubscribe")
+}
+
+// fakeSMTPServer accepts one connection and speaks just enough SMTP to take
+// a message, which it sends on the returned channel. With hang set it never
+// greets the client.
+func fakeSMTPServer(t *testing.T, hang bool) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if hang {
+			io.Copy(io.Discard, conn)
+			return
+		}
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(strings.Fields(line)[0]) {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				body, _ := tp.ReadDotBytes()
+				received <- string(body)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestSMTP_Send(t *testing.T) {
+	addr, received := fakeSMTPServer(t, false)
+	host, port, _ := net.SplitHostPort(addr)
+	portNumber, _ := strconv.Atoi(port)
+	m, err := New(NewSMTP(host, portNumber, "", ""), "no-reply@example.com", nil, nil)
+	assert.NoError(t, err)
+	err = m.Send(context.Background(), "alice@example.com", "user_welcome.tmpl", "", map[string]any{"activationToken": "TOKEN", "userID": 7})
+	assert.NoError(t, err)
+	assert.Contains(t, <-received, "To: alice@example.com")
+
+	// A server that never answers is abandoned when the context is done.
+	addr, _ = fakeSMTPServer(t, true)
+	host, port, _ = net.SplitHostPort(addr)
+	portNumber, _ = strconv.Atoi(port)
+	m, err = New(NewSMTP(host, portNumber, "", ""), "no-reply@example.com", nil, nil)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = m.Send(ctx, "alice@example.com", "user_welcome.tmpl", "", map[string]any{"activationToken": "TOKEN", "userID": 7})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestDKIMSigner_Sign(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	signer, err := NewDKIMSigner("example.com", "mail", pemKey)
+	assert.NoError(t, err)
+
+	// The body hash of an empty body is fixed by RFC 6376 section 3.4.4.
+	signed, err := signer.Sign([]byte("From: a@example.com\r\nSubject: Hi\r\n\r\n"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(signed), "bh=47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=;")
+
+	message := []byte("From: a@example.com\r\nTo: b@example.com\r\nSubject:  Hello\r\n  world\r\n\r\nBody  text \r\n\r\n")
+	signed, err = signer.Sign(message)
+	assert.NoError(t, err)
+	header, rest, _ := strings.Cut(string(signed), "\r\n")
+	assert.Equal(t, string(message), rest)
+	assert.Contains(t, header, "a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=mail;")
+	assert.Contains(t, header, "h=from:to:subject;")
+
+	// Recompute the signed data as a verifier would and check the signature.
+	i := strings.LastIndex(header, "; b=") + len("; b=")
+	sig, err := base64.StdEncoding.DecodeString(header[i:])
+	assert.NoError(t, err)
+	canonical := "from:a@example.com\r\nto:b@example.com\r\nsubject:Hello world\r\n" +
+		strings.TrimSuffix(relaxedHeader(header[:i]+"\r\n"), "\r\n")
+	digest := sha256.Sum256([]byte(canonical))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+}
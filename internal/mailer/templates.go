@@ -0,0 +1,205 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// DefaultLocale is the language of the templates in the root of the templates
+// directory.
+const DefaultLocale = "en"
+
+// sampleData is rendered with every template at startup, so a template that
+// refers to a missing field fails then rather than when a user signs up. It
+// is also what previews show. Every template needs an entry.
+var sampleData = map[string]map[string]any{
+	"user_welcome.tmpl": {
+		"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+		"userID":          123,
+	},
+}
+
+// SampleData returns the data used to preview a template.
+func SampleData(name string) map[string]any {
+	return sampleData[name]
+}
+
+// templateSet is one template file in one locale. The plain text parts are
+// rendered with text/template and the HTML part with html/template so that
+// values are escaped.
+type templateSet struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Registry holds every email template, parsed once. Templates live in the
+// root of the templates directory in the default language, with translations
+// in a subdirectory per language, e.g. fr/user_welcome.tmpl. Files in
+// layouts/ are shared by all of them; each template defines "subject",
+// "plainBody" and "htmlBody" and may use the layout and partials there.
+// Templates for mail that isn't transactional also define "unsubscribeURL".
+type Registry struct {
+	templates map[string]map[string]*templateSet // name, then locale ("" for the default)
+}
+
+func NewRegistry(fsys fs.FS) (*Registry, error) {
+	layouts, err := fs.Glob(fsys, "layouts/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	r := &Registry{templates: make(map[string]map[string]*templateSet)}
+	err = fs.WalkDir(fsys, ".", func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(file) != ".tmpl" || strings.HasPrefix(file, "layouts/") {
+			return nil
+		}
+		locale, name := path.Split(file)
+		locale = strings.Trim(locale, "/")
+		if strings.Contains(locale, "/") {
+			return fmt.Errorf("%s: templates may only be nested one directory deep", file)
+		}
+		set, err := parseTemplateSet(fsys, layouts, file)
+		if err != nil {
+			return err
+		}
+		if r.templates[name] == nil {
+			r.templates[name] = make(map[string]*templateSet)
+		}
+		r.templates[name][locale] = set
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, locales := range r.templates {
+		if locales[""] == nil {
+			return nil, fmt.Errorf("%s: translated but missing in the default language", name)
+		}
+		data, found := sampleData[name]
+		if !found {
+			return nil, fmt.Errorf("%s: no sample data", name)
+		}
+		for locale := range locales {
+			_, err := r.Render(name, locale, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+func parseTemplateSet(fsys fs.FS, layouts []string, file string) (*templateSet, error) {
+	files := append(append([]string{}, layouts...), file)
+	text, err := texttemplate.New("email").Option("missingkey=error").ParseFS(fsys, files...)
+	if err != nil {
+		return nil, err
+	}
+	html, err := htmltemplate.New("email").Option("missingkey=error").ParseFS(fsys, files...)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range []string{"subject", "plainBody", "htmlBody"} {
+		if text.Lookup(block) == nil {
+			return nil, fmt.Errorf("%s: missing %q block", file, block)
+		}
+	}
+	return &templateSet{text: text, html: html}, nil
+}
+
+// lookup finds the best variant of a template for locale: an exact match,
+// then the primary language ("pt" for "pt-br"), then the default.
+func (r *Registry) lookup(name, locale string) (*templateSet, error) {
+	locales, found := r.templates[name]
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+	locale = strings.ToLower(locale)
+	if set, found := locales[locale]; found {
+		return set, nil
+	}
+	language, _, _ := strings.Cut(locale, "-")
+	if set, found := locales[language]; found {
+		return set, nil
+	}
+	return locales[""], nil
+}
+
+// Render returns a message with the subject, bodies and unsubscribe URL filled
+// in from a template.
+func (r *Registry) Render(name, locale string, data any) (*Message, error) {
+	set, err := r.lookup(name, locale)
+	if err != nil {
+		return nil, err
+	}
+	subject := new(bytes.Buffer)
+	err = set.text.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+	plainBody := new(bytes.Buffer)
+	err = set.text.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+	htmlBody := new(bytes.Buffer)
+	err = set.html.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+	unsubscribe := new(bytes.Buffer)
+	if set.text.Lookup("unsubscribeURL") != nil {
+		err = set.text.ExecuteTemplate(unsubscribe, "unsubscribeURL", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Message{
+		Subject:     strings.TrimSpace(subject.String()),
+		Template:    name,
+		PlainBody:   plainBody.String(),
+		HTMLBody:    htmlBody.String(),
+		Unsubscribe: strings.TrimSpace(unsubscribe.String()),
+	}, nil
+}
+
+// Templates lists the template names with the locales each is translated
+// into, the default language first as "".
+func (r *Registry) Templates() map[string][]string {
+	templates := make(map[string][]string, len(r.templates))
+	for name, locales := range r.templates {
+		for locale := range locales {
+			templates[name] = append(templates[name], locale)
+		}
+		sort.Strings(templates[name])
+	}
+	return templates
+}
+
+// Locales lists the languages emails can be sent in: the default language and
+// every translation, sorted.
+func (r *Registry) Locales() []string {
+	locales := []string{DefaultLocale}
+	for _, translations := range r.templates {
+		for locale := range translations {
+			if locale != "" && !slices.Contains(locales, locale) {
+				locales = append(locales, locale)
+			}
+		}
+	}
+	sort.Strings(locales)
+	return locales
+}
@@ -0,0 +1,248 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sulfur.test.net/internal/data/jsonlog"
+)
+
+// Transport delivers rendered messages. Only SMTP touches the network; the
+// others exist so development and tests never send real email.
+type Transport interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// SMTP sends each message over its own connection. It speaks SMTP itself,
+// rather than through gomail's dialer, so that cancelling the context aborts
+// a dial or send that is in progress.
+type SMTP struct {
+	host     string
+	port     int
+	username string
+	password string
+	// timeout bounds a send when the context has no earlier deadline.
+	timeout time.Duration
+}
+
+func NewSMTP(host string, port int, username, password string) *SMTP {
+	return &SMTP{host: host, port: port, username: username, password: password, timeout: 10 * time.Second}
+}
+
+func (t *SMTP) Send(ctx context.Context, msg *Message) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(t.host, strconv.Itoa(t.port)))
+	if err != nil {
+		return err
+	}
+	// Closing the connection when ctx is done, including when the timeout
+	// passes, unblocks whatever the client is waiting for.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err = t.send(conn, from.Address, msg)
+	if ctx.Err() != nil {
+		return fmt.Errorf("smtp: %w", ctx.Err())
+	}
+	return err
+}
+
+func (t *SMTP) send(conn net.Conn, from string, msg *Message) error {
+	tlsConfig := &tls.Config{ServerName: t.host}
+	// Port 465 is SMTP over TLS rather than STARTTLS, as in gomail.
+	if t.port == 465 {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, t.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && t.port != 465 {
+		err = c.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if t.username != "" {
+		if ok, auths := c.Extension("AUTH"); ok {
+			err = c.Auth(t.auth(auths))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = c.Mail(from)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	// msg writes itself, so a DKIM signature covers exactly what is sent.
+	_, err = msg.WriteTo(w)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// auth picks a mechanism the server offers, the same way gomail does.
+func (t *SMTP) auth(offered string) smtp.Auth {
+	switch {
+	case strings.Contains(offered, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(t.username, t.password)
+	case strings.Contains(offered, "LOGIN") && !strings.Contains(offered, "PLAIN"):
+		return &loginAuth{username: t.username, password: t.password, host: t.host}
+	default:
+		return smtp.PlainAuth("", t.username, t.password, t.host)
+	}
+}
+
+// loginAuth is the LOGIN mechanism, which net/smtp doesn't implement. Like
+// smtp.PlainAuth it refuses to send the password unencrypted to anything but
+// localhost.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && a.host != "localhost" && a.host != "127.0.0.1" && a.host != "::1" {
+		return "", nil, errors.New("smtp: unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("smtp: wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch {
+	case bytes.EqualFold(fromServer, []byte("Username:")):
+		return []byte(a.username), nil
+	case bytes.EqualFold(fromServer, []byte("Password:")):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("smtp: unexpected server challenge: %s", fromServer)
+}
+
+// Outbox writes each message to its own .eml file in a directory, where it
+// can be opened with any mail client.
+type Outbox struct {
+	dir string
+}
+
+func NewOutbox(dir string) (*Outbox, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &Outbox{dir: dir}, nil
+}
+
+func (t *Outbox) Send(ctx context.Context, msg *Message) error {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	// Names sort in the order the messages were sent.
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(b) + ".eml"
+
+	f, err := os.CreateTemp(t.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = msg.WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(t.dir, name))
+}
+
+// Log writes messages to the application log instead of sending them. It's
+// meant for development: bodies, and any tokens in them, end up in the log.
+type Log struct {
+	logger *jsonlog.Logger
+}
+
+func NewLog(logger *jsonlog.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+func (t *Log) Send(ctx context.Context, msg *Message) error {
+	t.logger.PrintInfo("email", map[string]any{
+		"from":     msg.From,
+		"to":       msg.To,
+		"subject":  msg.Subject,
+		"template": msg.Template,
+		"body":     msg.PlainBody,
+	})
+	return nil
+}
+
+// Recorder keeps the messages it is given, for tests to inspect.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (t *Recorder) Send(ctx context.Context, msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (t *Recorder) Messages() []*Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Message(nil), t.messages...)
+}
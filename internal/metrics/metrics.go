@@ -0,0 +1,248 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the Prometheus client default histogram buckets, in
+// seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry collects metrics and renders them in the Prometheus text
+// exposition format (version 0.0.4).
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	return buf.Flush()
+}
+
+func (r *Registry) Handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+type desc struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+func (d desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.name, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels renders {a="1",b="2"}, appending the extra name/value pair (used for
+// histogram "le") when extraName isn't empty.
+func (d desc) labels(key string, extraName, extraValue string) string {
+	var pairs []string
+	if len(d.labelNames) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, d.labelNames[i], labelValueReplacer.Replace(value)))
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Vec is a counter or gauge partitioned by label values.
+type Vec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newVec(r *Registry, kind, name, help string, labelNames []string) *Vec {
+	v := &Vec{
+		desc:   desc{name: name, help: help, kind: kind, labelNames: labelNames},
+		values: make(map[string]float64),
+	}
+	r.register(v)
+	return v
+}
+
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Vec {
+	return newVec(r, "counter", name, help, labelNames)
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Vec {
+	return newVec(r, "gauge", name, help, labelNames)
+}
+
+func (v *Vec) Add(delta float64, labelValues ...string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] += delta
+}
+
+func (v *Vec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+func (v *Vec) Dec(labelValues ...string) {
+	v.Add(-1, labelValues...)
+}
+
+func (v *Vec) Set(value float64, labelValues ...string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] = value
+}
+
+func (v *Vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.writeHeader(w)
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labels(key, "", ""), formatFloat(v.values[key]))
+	}
+}
+
+// Func is an unlabelled counter or gauge whose value is read at scrape time,
+// e.g. from sql.DB.Stats.
+type Func struct {
+	desc
+	fn func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *Func {
+	f := &Func{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn}
+	r.register(f)
+	return f
+}
+
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) *Func {
+	f := &Func{desc: desc{name: name, help: help, kind: "counter"}, fn: fn}
+	r.register(f)
+	return f
+}
+
+func (f *Func) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+}
+
+type histogramSeries struct {
+	counts []uint64 // Non-cumulative count per bucket, plus one for +Inf
+	sum    float64
+	count  uint64
+}
+
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labelNames: labelNames},
+		buckets: append([]float64(nil), buckets...),
+		series:  make(map[string]*histogramSeries),
+	}
+	sort.Float64s(h.buckets)
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, found := h.series[key]
+	if !found {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, value)]++
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(key, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels(key, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels(key, "", ""), s.count)
+	}
+}
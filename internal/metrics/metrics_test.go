@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("http_requests_total", "Requests served.", "method", "route")
+	inFlight := r.NewGauge("http_requests_in_flight", "Requests being served.")
+	duration := r.NewHistogram("http_request_duration_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	r.NewGaugeFunc("db_open_connections", "Open connections.", func() float64 { return 3 })
+
+	requests.Inc("GET", "/v1/movies/:id")
+	requests.Inc("GET", "/v1/movies/:id")
+	requests.Inc("POST", `/weird"route`)
+	inFlight.Inc()
+	duration.Observe(0.05, "/v1/movies")
+	duration.Observe(0.5, "/v1/movies")
+	duration.Observe(3, "/v1/movies")
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, r.WriteText(buf))
+	assert.Equal(t, `# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="/v1/movies/:id"} 2
+http_requests_total{method="POST",route="/weird\"route"} 1
+# HELP http_requests_in_flight Requests being served.
+# TYPE http_requests_in_flight gauge
+http_requests_in_flight 1
+# HELP http_request_duration_seconds Request latency.
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{route="/v1/movies",le="0.1"} 1
+http_request_duration_seconds_bucket{route="/v1/movies",le="1"} 2
+http_request_duration_seconds_bucket{route="/v1/movies",le="+Inf"} 3
+http_request_duration_seconds_sum{route="/v1/movies"} 3.55
+http_request_duration_seconds_count{route="/v1/movies"} 3
+# HELP db_open_connections Open connections.
+# TYPE db_open_connections gauge
+db_open_connections 3
+`, buf.String())
+}
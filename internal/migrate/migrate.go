@@ -0,0 +1,366 @@
+// Package migrate applies the SQL migrations in a directory of
+// NNN_name.up.sql and NNN_name.down.sql files. It keeps its state in the
+// schema_migrations table the same way as golang-migrate, so the two can be
+// used on the same database.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// NoVersion is the version of a database with no migrations applied.
+const NoVersion int64 = -1
+
+var (
+	ErrDirty          = errors.New("migrate: a migration failed part way and left the schema dirty; fix it by hand, then force the version")
+	ErrUnknownVersion = errors.New("migrate: unknown version")
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+	hasUp   bool
+	hasDown bool
+}
+
+// Step is one migration run in one direction.
+type Step struct {
+	Migration
+	Up bool
+}
+
+func (s Step) String() string {
+	direction := "down"
+	if s.Up {
+		direction = "up"
+	}
+	return fmt.Sprintf("%d_%s.%s", s.Version, s.Name, direction)
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration // by version
+	// OnStep, if set, is called before each step runs.
+	OnStep func(step Step)
+}
+
+var fileRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// New reads the migrations in the root of fsys.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		match := fileRX.FindStringSubmatch(file)
+		if match == nil {
+			return nil, fmt.Errorf("migrate: %s: file name must be NNN_name.up.sql or NNN_name.down.sql", file)
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s: %w", file, err)
+		}
+		b, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d has two names, %q and %q", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.up = string(b)
+			migration.hasUp = true
+		} else {
+			migration.down = string(b)
+			migration.hasDown = true
+		}
+	}
+
+	m := &Migrator{db: db}
+	for _, migration := range byVersion {
+		if !migration.hasUp {
+			return nil, fmt.Errorf("migrate: version %d has no up migration", migration.Version)
+		}
+		m.migrations = append(m.migrations, *migration)
+	}
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+	return m, nil
+}
+
+// Latest returns the version of the newest migration, or NoVersion if there
+// are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return NoVersion
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the database and whether it is dirty. It
+// takes no lock, so a migration may be running, and changes nothing: a
+// database without the schema_migrations table is at NoVersion.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+	var exists bool
+	err = conn.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return 0, false, err
+	}
+	if !exists {
+		return NoVersion, false, nil
+	}
+	return readVersion(ctx, conn)
+}
+
+// Up applies every migration not yet applied. A database newer than the
+// migrations, e.g. during a rollback, is left alone.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn, current int64) error {
+		if current >= m.Latest() {
+			return nil
+		}
+		return m.run(ctx, conn, current, m.Latest())
+	})
+}
+
+// Down reverts the last n migrations.
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	return m.locked(ctx, func(conn *sql.Conn, current int64) error {
+		if current == NoVersion {
+			return nil
+		}
+		i := m.index(current)
+		if i < 0 {
+			return fmt.Errorf("%w %d: the database is newer than this build", ErrUnknownVersion, current)
+		}
+		if n > i+1 {
+			return fmt.Errorf("migrate: can't revert %d migrations, only %d are applied", n, i+1)
+		}
+		target := NoVersion
+		if i-n >= 0 {
+			target = m.migrations[i-n].Version
+		}
+		return m.run(ctx, conn, current, target)
+	})
+}
+
+// Goto migrates up or down to version.
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	return m.locked(ctx, func(conn *sql.Conn, current int64) error {
+		return m.run(ctx, conn, current, version)
+	})
+}
+
+// Force sets the version without running any migrations and clears the dirty
+// flag, after a failed migration has been repaired by hand.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if version != NoVersion && m.index(version) < 0 {
+		return fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return withLock(ctx, conn, func() error {
+		err := ensureTable(ctx, conn)
+		if err != nil {
+			return err
+		}
+		return setVersion(ctx, conn, version, false)
+	})
+}
+
+// Plan returns the steps that take the database from version current to
+// target, in order.
+func (m *Migrator) Plan(current, target int64) ([]Step, error) {
+	for _, version := range []int64{current, target} {
+		if version != NoVersion && m.index(version) < 0 {
+			return nil, fmt.Errorf("%w %d", ErrUnknownVersion, version)
+		}
+	}
+	var steps []Step
+	if target >= current {
+		for _, migration := range m.migrations {
+			if migration.Version > current && migration.Version <= target {
+				steps = append(steps, Step{Migration: migration, Up: true})
+			}
+		}
+		return steps, nil
+	}
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version > target && migration.Version <= current {
+			if !migration.hasDown {
+				return nil, fmt.Errorf("migrate: version %d has no down migration", migration.Version)
+			}
+			steps = append(steps, Step{Migration: migration})
+		}
+	}
+	return steps, nil
+}
+
+// index returns the position of version in m.migrations, or -1.
+func (m *Migrator) index(version int64) int {
+	i := sort.Search(len(m.migrations), func(i int) bool {
+		return m.migrations[i].Version >= version
+	})
+	if i < len(m.migrations) && m.migrations[i].Version == version {
+		return i
+	}
+	return -1
+}
+
+// run applies the steps from current to target. As in golang-migrate, the
+// version is marked dirty while each step runs: the target version of the
+// step for up migrations and the version below it for down ones. Steps don't
+// run in a transaction, so files may use statements such as CREATE INDEX
+// CONCURRENTLY.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, current, target int64) error {
+	steps, err := m.Plan(current, target)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		version := step.Version
+		query := step.up
+		if !step.Up {
+			version = m.previous(step.Version)
+			query = step.down
+		}
+		if m.OnStep != nil {
+			m.OnStep(step)
+		}
+		err := setVersion(ctx, conn, version, true)
+		if err == nil {
+			_, err = conn.ExecContext(ctx, query)
+		}
+		if err == nil {
+			err = setVersion(ctx, conn, version, false)
+		}
+		if err != nil {
+			return fmt.Errorf("migrate: %s: %w", step, err)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) previous(version int64) int64 {
+	i := m.index(version)
+	if i <= 0 {
+		return NoVersion
+	}
+	return m.migrations[i-1].Version
+}
+
+// locked runs fn holding the migration lock, with the current version. It
+// refuses to run on a dirty database.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, current int64) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return withLock(ctx, conn, func() error {
+		err := ensureTable(ctx, conn)
+		if err != nil {
+			return err
+		}
+		current, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("%w (version %d)", ErrDirty, current)
+		}
+		return fn(conn, current)
+	})
+}
+
+// withLock holds a session advisory lock on conn while fn runs. The key is
+// derived the same way as by golang-migrate's postgres driver (v4.15 and
+// later), so this runner and the migrate CLI wait for each other.
+func withLock(ctx context.Context, conn *sql.Conn, fn func() error) error {
+	var database, schema string
+	err := conn.QueryRowContext(ctx, `SELECT current_database(), current_schema()`).Scan(&database, &schema)
+	if err != nil {
+		return err
+	}
+	key := lockKey(database, schema, "schema_migrations")
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, key)
+	if err != nil {
+		return fmt.Errorf("migrate: taking lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+	return fn()
+}
+
+const lockKeySalt = 1486364155
+
+func lockKey(database string, names ...string) int64 {
+	key := ""
+	for _, name := range names {
+		key += name + "\x00"
+	}
+	sum := crc32.ChecksumIEEE([]byte(key + database))
+	return int64(sum * lockKeySalt)
+}
+
+func ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	return err
+}
+
+func readVersion(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	var version int64
+	var dirty bool
+	err := conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return NoVersion, false, nil
+	}
+	return version, dirty, err
+}
+
+func setVersion(ctx context.Context, conn *sql.Conn, version int64, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `TRUNCATE schema_migrations`)
+	if err != nil {
+		return err
+	}
+	// Like golang-migrate, a failed step down to NoVersion is recorded as
+	// version -1 and dirty, rather than as an empty table that looks clean.
+	if version != NoVersion || dirty {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, version, dirty)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,75 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"000001_create_movies.up.sql":     {Data: []byte("CREATE TABLE movies ();")},
+		"000001_create_movies.down.sql":   {Data: []byte("DROP TABLE movies;")},
+		"000002_add_index.up.sql":         {Data: []byte("CREATE INDEX ON movies (id);")},
+		"000002_add_index.down.sql":       {Data: []byte("DROP INDEX movies_id_idx;")},
+		"000010_create_users.up.sql":      {Data: []byte("CREATE TABLE users ();")},
+		"000010_create_users.down.sql":    {Data: []byte("DROP TABLE users;")},
+		"000011_add_permissions.up.sql":   {Data: []byte("INSERT INTO permissions VALUES ('x');")},
+		"000011_add_permissions.down.sql": {Data: []byte("")},
+	}
+}
+
+func TestNew(t *testing.T) {
+	m, err := New(nil, testMigrations())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), m.Latest())
+
+	fsys := testMigrations()
+	fsys["000003_bad.sql"] = &fstest.MapFile{}
+	_, err = New(nil, fsys)
+	assert.Error(t, err)
+
+	fsys = testMigrations()
+	fsys["000012_only_down.down.sql"] = &fstest.MapFile{}
+	_, err = New(nil, fsys)
+	assert.ErrorContains(t, err, "no up migration")
+}
+
+func TestPlan(t *testing.T) {
+	m, err := New(nil, testMigrations())
+	assert.NoError(t, err)
+
+	tests := []struct {
+		current, target int64
+		want            []string
+	}{
+		{NoVersion, 11, []string{"1_create_movies.up", "2_add_index.up", "10_create_users.up", "11_add_permissions.up"}},
+		{2, 10, []string{"10_create_users.up"}},
+		{11, 2, []string{"11_add_permissions.down", "10_create_users.down"}},
+		{2, NoVersion, []string{"2_add_index.down", "1_create_movies.down"}},
+		{10, 10, nil},
+	}
+	for _, tt := range tests {
+		steps, err := m.Plan(tt.current, tt.target)
+		assert.NoError(t, err)
+		var got []string
+		for _, step := range steps {
+			got = append(got, step.String())
+		}
+		assert.Equal(t, tt.want, got, "%d to %d", tt.current, tt.target)
+	}
+
+	_, err = m.Plan(2, 5)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+	assert.Equal(t, int64(2), m.previous(10))
+	assert.Equal(t, NoVersion, m.previous(1))
+}
+
+func TestLockKey(t *testing.T) {
+	// golang-migrate's postgres driver locks
+	// crc32("public\x00schema_migrations\x00greenlight") * 1486364155; the
+	// CLI and the runner only exclude each other if the keys match.
+	assert.Equal(t, int64(4080500878), lockKey("greenlight", "public", "schema_migrations"))
+	assert.Equal(t, int64(1015268294), lockKey("other", "public", "schema_migrations"))
+}
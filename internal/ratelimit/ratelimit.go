@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Policy is a token bucket refilled at RPS tokens per second holding at most
+// Burst tokens.
+type Policy struct {
+	RPS   float64
+	Burst int
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // How long until the next request would be allowed, zero if Allowed
+	Reset      time.Duration // How long until the bucket is full again
+}
+
+// Limiter decides whether the client identified by key may make another
+// request under policy. Close stops its background pruning.
+type Limiter interface {
+	Allow(key string, policy Policy) (Result, error)
+	Close() error
+}
+
+// resetAfter estimates how long a bucket with tokens left takes to refill.
+func resetAfter(tokens float64, policy Policy) time.Duration {
+	missing := float64(policy.Burst) - tokens
+	if missing <= 0 || policy.RPS <= 0 {
+		return 0
+	}
+	return time.Duration(missing / policy.RPS * float64(time.Second))
+}
+
+func remaining(tokens float64) int {
+	return int(math.Max(0, math.Floor(tokens)))
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Memory keeps one rate.Limiter per key in process memory. Keys not seen for
+// three minutes are forgotten.
+type Memory struct {
+	mu      sync.Mutex
+	clients map[string]*client
+	stop    stopper
+}
+
+func NewMemory() *Memory {
+	m := &Memory{clients: make(map[string]*client)}
+	m.stop.every(time.Minute, func() {
+		m.mu.Lock()
+		for key, client := range m.clients {
+			if time.Since(client.lastSeen) > 3*time.Minute {
+				delete(m.clients, key)
+			}
+		}
+		m.mu.Unlock()
+	})
+	return m
+}
+
+func (m *Memory) Close() error {
+	m.stop.close()
+	return nil
+}
+
+// stopper runs a function periodically in a goroutine until closed.
+type stopper struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func (s *stopper) every(interval time.Duration, fn func()) {
+	s.done = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+func (s *stopper) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (m *Memory) Allow(key string, policy Policy) (Result, error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, found := m.clients[key]
+	if !found {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(policy.RPS), policy.Burst)}
+		m.clients[key] = c
+	}
+	// The policy for a key can change when the configuration is reloaded.
+	if c.limiter.Limit() != rate.Limit(policy.RPS) {
+		c.limiter.SetLimitAt(now, rate.Limit(policy.RPS))
+	}
+	if c.limiter.Burst() != policy.Burst {
+		c.limiter.SetBurstAt(now, policy.Burst)
+	}
+	c.lastSeen = now
+
+	result := Result{Limit: policy.Burst}
+	reservation := c.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		result.RetryAfter = time.Second
+		return result, nil
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		result.RetryAfter = delay
+		tokens := c.limiter.TokensAt(now)
+		result.Reset = resetAfter(tokens, policy)
+		return result, nil
+	}
+	tokens := c.limiter.TokensAt(now)
+	result.Allowed = true
+	result.Remaining = remaining(tokens)
+	result.Reset = resetAfter(tokens, policy)
+	return result, nil
+}
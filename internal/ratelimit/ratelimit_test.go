@@ -0,0 +1,29 @@
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory_Allow(t *testing.T) {
+	m := NewMemory()
+	policy := Policy{RPS: 0.001, Burst: 2}
+
+	result, err := m.Allow("ip:192.0.2.1", policy)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Limit)
+	assert.Equal(t, 1, result.Remaining)
+
+	result, _ = m.Allow("ip:192.0.2.1", policy)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	result, _ = m.Allow("ip:192.0.2.1", policy)
+	assert.False(t, result.Allowed)
+	assert.Greater(t, result.RetryAfter.Seconds(), 900.0)
+
+	result, _ = m.Allow("user:1", policy)
+	assert.True(t, result.Allowed, "keys must have separate buckets")
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps token buckets somewhere every API replica can reach.
+type Store interface {
+	Take(key string, rps float64, burst int, timeout time.Duration) (tokens float64, allowed bool, err error)
+	Prune(olderThan time.Duration) (int64, error)
+}
+
+// Shared enforces a policy across replicas by keeping the buckets in a
+// Store. Requests are checked against a local Memory limiter first, so a
+// client already over its budget on this replica is rejected without a round
+// trip. When the store fails or is slower than timeout the local decision is
+// used instead and the store is bypassed for backoff, so a struggling
+// database degrades limits to per-replica rather than failing requests.
+type Shared struct {
+	local   *Memory
+	store   Store
+	timeout time.Duration
+	backoff time.Duration
+	onError func(error)
+
+	mu      sync.Mutex
+	retryAt time.Time
+	stop    stopper
+}
+
+func NewShared(store Store, timeout time.Duration, onError func(error)) *Shared {
+	s := &Shared{
+		local:   NewMemory(),
+		store:   store,
+		timeout: timeout,
+		backoff: 5 * time.Second,
+		onError: onError,
+	}
+	s.stop.every(time.Minute, func() {
+		_, err := s.store.Prune(3 * time.Minute)
+		if err != nil {
+			s.onError(err)
+		}
+	})
+	return s
+}
+
+// Close stops pruning the store and the local limiter.
+func (s *Shared) Close() error {
+	s.stop.close()
+	return s.local.Close()
+}
+
+func (s *Shared) Allow(key string, policy Policy) (Result, error) {
+	result, err := s.local.Allow(key, policy)
+	if err != nil || !result.Allowed {
+		return result, err
+	}
+
+	s.mu.Lock()
+	bypass := time.Now().Before(s.retryAt)
+	s.mu.Unlock()
+	if bypass {
+		return result, nil
+	}
+
+	tokens, allowed, err := s.store.Take(key, policy.RPS, policy.Burst, s.timeout)
+	if err != nil {
+		s.mu.Lock()
+		s.retryAt = time.Now().Add(s.backoff)
+		s.mu.Unlock()
+		s.onError(err)
+		return result, nil
+	}
+
+	result = Result{
+		Allowed:   allowed,
+		Limit:     policy.Burst,
+		Remaining: remaining(tokens),
+		Reset:     resetAfter(tokens, policy),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / policy.RPS * float64(time.Second))
+	}
+	return result, nil
+}
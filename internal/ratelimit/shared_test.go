@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStore allows every request until failing is set, and counts calls.
+type fakeStore struct {
+	mu      sync.Mutex
+	failing bool
+	allowed bool
+	takes   int
+}
+
+func (s *fakeStore) Take(key string, rps float64, burst int, timeout time.Duration) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.takes++
+	if s.failing {
+		return 0, false, errors.New("store unavailable")
+	}
+	return 0, s.allowed, nil
+}
+
+func (s *fakeStore) Prune(olderThan time.Duration) (int64, error) {
+	return 0, nil
+}
+
+func (s *fakeStore) set(failing, allowed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing, s.allowed = failing, allowed
+}
+
+func (s *fakeStore) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.takes
+}
+
+func TestShared_Fallback(t *testing.T) {
+	store := &fakeStore{}
+	var errs []error
+	s := NewShared(store, time.Second, func(err error) { errs = append(errs, err) })
+	defer s.Close()
+	s.backoff = 50 * time.Millisecond
+	policy := Policy{RPS: 100, Burst: 100}
+
+	// The store has the final say while it works.
+	result, err := s.Allow("ip:192.0.2.1", policy)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 1, store.calls())
+
+	// A failure falls back to the local decision and skips the store for the
+	// backoff period.
+	store.set(true, false)
+	result, err = s.Allow("ip:192.0.2.1", policy)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Len(t, errs, 1)
+	result, _ = s.Allow("ip:192.0.2.1", policy)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, store.calls(), "store bypassed during backoff")
+
+	// After the backoff the recovered store is used again.
+	store.set(false, false)
+	time.Sleep(60 * time.Millisecond)
+	result, _ = s.Allow("ip:192.0.2.1", policy)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 3, store.calls())
+	assert.Len(t, errs, 1)
+}
+
+func TestShared_LocalRejectsFirst(t *testing.T) {
+	store := &fakeStore{allowed: true}
+	s := NewShared(store, time.Second, func(error) {})
+	defer s.Close()
+	policy := Policy{RPS: 0.001, Burst: 1}
+
+	result, _ := s.Allow("user:1", policy)
+	assert.True(t, result.Allowed)
+	result, _ = s.Allow("user:1", policy)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 1, store.calls(), "over budget locally, so the store isn't asked")
+}
+
+func TestClose(t *testing.T) {
+	m := NewMemory()
+	assert.NoError(t, m.Close())
+	assert.NoError(t, m.Close(), "closing twice is safe")
+	s := NewShared(&fakeStore{}, time.Second, func(error) {})
+	assert.NoError(t, s.Close())
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// WriterExporter writes each span as a line of JSON, using the field names of
+// the OpenTelemetry OTLP/JSON span so the output can be read by tools that
+// understand it.
+type WriterExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewWriterExporter(out io.Writer) *WriterExporter {
+	return &WriterExporter{out: out}
+}
+
+// NewFileExporter appends spans to the file at path, creating it if needed.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterExporter{out: f}, nil
+}
+
+type jsonSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              string         `json:"kind"`
+	StartTimeUnixNano int64          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   int64          `json:"endTimeUnixNano"`
+	DurationMS        float64        `json:"durationMs"`
+	Attributes        map[string]any `json:"attributes,omitempty"`
+	Status            *jsonStatus    `json:"status,omitempty"`
+}
+
+type jsonStatus struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *WriterExporter) Export(span SpanData) error {
+	js := jsonSpan{
+		TraceID:           span.TraceID.String(),
+		SpanID:            span.SpanID.String(),
+		Name:              span.Name,
+		Kind:              span.Kind,
+		StartTimeUnixNano: span.Start.UnixNano(),
+		EndTimeUnixNano:   span.End.UnixNano(),
+		DurationMS:        float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+		Attributes:        span.Attributes,
+	}
+	if span.ParentSpanID.IsValid() {
+		js.ParentSpanID = span.ParentSpanID.String()
+	}
+	if span.Error != "" {
+		js.Status = &jsonStatus{Code: "error", Message: span.Error}
+	}
+	line, err := json.Marshal(js)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.out.Write(append(line, '\n'))
+	return err
+}
+
+// Shutdown closes the output if it's a file the exporter opened or was
+// handed; os.Stdout and os.Stderr are left open.
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.out == os.Stdout || e.out == os.Stderr {
+		return nil
+	}
+	if c, ok := e.out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span that crosses process boundaries in the
+// W3C traceparent header.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a version 00 traceparent header
+// value, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. Headers from future
+// versions are accepted as long as they start with the version 00 fields.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return SpanContext{}, false
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Extract returns the remote parent span context sent in the traceparent
+// header, if there is a valid one.
+func Extract(header http.Header) (SpanContext, bool) {
+	return ParseTraceparent(header.Get("traceparent"))
+}
+
+// Inject sets the traceparent header for an outgoing request made within the
+// span in ctx.
+func Inject(ctx context.Context, header http.Header) {
+	if sc := SpanFromContext(ctx).Context(); sc.IsValid() {
+		header.Set("traceparent", sc.Traceparent())
+	}
+}
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	Name         string
+	Kind         string
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]any
+	Error        string
+}
+
+// Exporter ships finished, sampled spans somewhere. Export is called once per
+// span as it ends, from the goroutine that ended it, so exporters which talk
+// to a network collector should buffer.
+type Exporter interface {
+	Export(span SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer starts root spans. Child spans are started with the package level
+// Start function and belong to the tracer of their parent.
+type Tracer struct {
+	exporter Exporter
+	ratio    float64
+	onError  func(error)
+}
+
+// New creates a tracer which samples the given ratio (0 to 1) of new traces.
+// Traces continued from a traceparent header keep the caller's decision.
+// onError is called when the exporter fails and may be nil.
+func New(exporter Exporter, ratio float64, onError func(error)) *Tracer {
+	return &Tracer{exporter: exporter, ratio: ratio, onError: onError}
+}
+
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil || t.exporter == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// Start begins a span which is a child of the span in ctx if there is one,
+// otherwise of remote when that is valid, and otherwise a new trace.
+//
+// A nil tracer traces nothing, like Start without a span in ctx.
+func (t *Tracer) Start(ctx context.Context, name string, remote SpanContext) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		return Start(ctx, name)
+	}
+	sc := remote
+	parentID := remote.SpanID
+	if !remote.IsValid() {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = t.sample(sc.TraceID)
+		parentID = SpanID{}
+	}
+	return t.start(ctx, name, sc, parentID)
+}
+
+// sample makes the same decision for a trace ID wherever it is made, so a
+// ratio below 1 keeps whole traces.
+func (t *Tracer) sample(id TraceID) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	if t.ratio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>11) < t.ratio*(1<<53)
+}
+
+func (t *Tracer) start(ctx context.Context, name string, sc SpanContext, parent SpanID) (context.Context, *Span) {
+	rand.Read(sc.SpanID[:])
+	span := &Span{
+		tracer: t,
+		sc:     sc,
+		data: SpanData{
+			Name:         name,
+			Kind:         "internal",
+			TraceID:      sc.TraceID,
+			SpanID:       sc.SpanID,
+			ParentSpanID: parent,
+			Start:        time.Now(),
+		},
+	}
+	return context.WithValue(ctx, spanContextKey, span), span
+}
+
+type contextKey string
+
+const spanContextKey = contextKey("span")
+
+// SpanFromContext returns the current span, or nil. All Span methods can be
+// called on a nil span, so callers needn't check.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey).(*Span)
+	return span
+}
+
+// Start begins a child of the span in ctx. Without a span in ctx nothing is
+// traced and the returned span is nil.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.start(ctx, name, SpanContext{TraceID: parent.sc.TraceID, Sampled: parent.sc.Sampled}, parent.sc.SpanID)
+}
+
+// Detach returns a context without the deadline or cancellation of ctx but
+// with its current span, so background work outliving a request can still be
+// traced as part of it.
+func Detach(ctx context.Context) context.Context {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return context.Background()
+	}
+	return context.WithValue(context.Background(), spanContextKey, span)
+}
+
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetKind records the OpenTelemetry span kind, e.g. "server"; spans are
+// "internal" by default.
+func (s *Span) SetKind(kind string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Kind = kind
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any)
+	}
+	s.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and exports it if it was sampled. Only the first call
+// has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if !s.sc.Sampled || s.tracer.exporter == nil {
+		return
+	}
+	err := s.tracer.exporter.Export(data)
+	if err != nil && s.tracer.onError != nil {
+		s.tracer.onError(err)
+	}
+}
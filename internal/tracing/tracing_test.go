@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"future version with extra field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra", true},
+		{"version 00 with extra field", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"short span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, valid := ParseTraceparent(tt.value)
+			assert.Equal(t, tt.valid, valid)
+		})
+	}
+
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+}
+
+func TestTracer(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tracer := New(NewWriterExporter(buf), 1, nil)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tracer.Start(context.Background(), "GET", remote)
+	root.SetKind("server")
+	childCtx, child := Start(ctx, "db MovieModel.Get")
+	child.SetAttribute("db.system", "postgresql")
+	child.RecordError(errors.New("record not found"))
+	child.End()
+
+	header := make(http.Header)
+	Inject(childCtx, header)
+	assert.Equal(t, child.Context().Traceparent(), header.Get("traceparent"))
+
+	root.End()
+	root.End()
+
+	var spans []jsonSpan
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var span jsonSpan
+		assert.NoError(t, json.Unmarshal([]byte(line), &span))
+		spans = append(spans, span)
+	}
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "db MovieModel.Get", spans[0].Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, "record not found", spans[0].Status.Message)
+	assert.Equal(t, "postgresql", spans[0].Attributes["db.system"])
+	assert.Equal(t, "00f067aa0ba902b7", spans[1].ParentSpanID)
+	assert.Equal(t, "server", spans[1].Kind)
+}
+
+func TestTracer_Unsampled(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tracer := New(NewWriterExporter(buf), 0, nil)
+
+	ctx, root := tracer.Start(context.Background(), "GET", SpanContext{})
+	_, child := Start(ctx, "child")
+	assert.True(t, child.Context().IsValid())
+	assert.Equal(t, root.Context().TraceID, child.Context().TraceID)
+	child.End()
+	root.End()
+	assert.Empty(t, buf.String())
+
+	// Without a span in the context nothing is traced.
+	_, span := Start(context.Background(), "orphan")
+	assert.Nil(t, span)
+	span.SetAttribute("key", "value")
+	span.End()
+}
@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code = 'genres:write';
+DROP TRIGGER IF EXISTS genres_sync_names ON genres;
+DROP FUNCTION IF EXISTS genres_sync_names();
+DROP TABLE IF EXISTS genre_names;
+DROP TABLE IF EXISTS genres;
@@ -0,0 +1,55 @@
+CREATE TABLE IF NOT EXISTS genres (
+id bigserial PRIMARY KEY,
+slug text UNIQUE NOT NULL,
+name text NOT NULL,
+aliases text[] NOT NULL DEFAULT '{}',
+version integer NOT NULL DEFAULT 1
+);
+CREATE INDEX IF NOT EXISTS genres_aliases_idx ON genres USING GIN (aliases);
+
+-- Every slug and alias names at most one genre. Arrays can't carry a unique
+-- constraint, so a trigger keeps this table in step with genres.
+CREATE TABLE IF NOT EXISTS genre_names (
+name text PRIMARY KEY,
+genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE
+);
+
+CREATE OR REPLACE FUNCTION genres_sync_names() RETURNS trigger AS $$
+BEGIN
+    DELETE FROM genre_names WHERE genre_id = NEW.id;
+    INSERT INTO genre_names (name, genre_id)
+    SELECT DISTINCT name, NEW.id FROM unnest(NEW.slug || NEW.aliases) AS name;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER genres_sync_names
+AFTER INSERT OR UPDATE OF slug, aliases ON genres
+FOR EACH ROW EXECUTE FUNCTION genres_sync_names();
+
+INSERT INTO genres (slug, name)
+SELECT slug, initcap(replace(slug, '-', ' '))
+FROM (
+    SELECT DISTINCT lower(regexp_replace(trim(g), '[\s_]+', '-', 'g')) AS slug
+    FROM movies, unnest(movies.genres) AS g
+) AS existing
+WHERE slug <> ''
+ON CONFLICT (slug) DO NOTHING;
+
+-- A movie without genres keeps an empty array rather than NULL.
+UPDATE movies SET genres = COALESCE((
+    SELECT array_agg(slug ORDER BY position)
+    FROM (
+        SELECT slug, min(ord) AS position
+        FROM (
+            SELECT lower(regexp_replace(trim(g), '[\s_]+', '-', 'g')) AS slug, ord
+            FROM unnest(movies.genres) WITH ORDINALITY AS u(g, ord)
+        ) AS slugs
+        WHERE slug <> ''
+        GROUP BY slug
+    ) AS normalised
+), '{}');
+
+INSERT INTO permissions (code)
+VALUES
+    ('genres:write');
@@ -0,0 +1,12 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS synopsis text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS movie_translations (
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+language text NOT NULL,
+search_config regconfig NOT NULL DEFAULT 'simple',
+title text NOT NULL,
+synopsis text NOT NULL DEFAULT '',
+version integer NOT NULL DEFAULT 1,
+PRIMARY KEY (movie_id, language)
+);
+CREATE INDEX IF NOT EXISTS movie_translations_search_idx ON movie_translations USING GIN (to_tsvector(search_config, title || ' ' || synopsis));
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    kind text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL,
+    run_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp(0) with time zone,
+    last_error text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT jobs_status_check CHECK (status IN ('pending', 'running', 'succeeded', 'dead'))
+);
+
+CREATE INDEX IF NOT EXISTS jobs_runnable_idx ON jobs (run_at) WHERE status IN ('pending', 'running');
+
+INSERT INTO permissions (code)
+VALUES
+    ('jobs:read'),
+    ('jobs:write');
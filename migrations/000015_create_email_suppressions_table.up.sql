@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    email citext PRIMARY KEY,
+    reason text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
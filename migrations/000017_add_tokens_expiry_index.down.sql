@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY IF EXISTS tokens_expiry_idx;
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations so the binary knows which
+// schema version it was built against.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS